@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/fs"
 	"io/ioutil" // TODO: shouldn't need this anymore
+	"math"
 
 	//"log"
 
@@ -40,6 +41,9 @@ var gPackageMode = false
 var gFindTestVal string
 var gFindTestCoverage = false
 var flagTidCsvPath string
+var flagLayerPath string
+
+var gCoveredTests = map[string]bool{} // "T1234#1" of atomic tests that have criteria, see FindCoverage()
 
 // sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-test.bash
 var gRxUnixRedirect = regexp.MustCompile(`\d?>>?[ ]?([#{}._/\-0-9A-Za-z ]+)`)
@@ -57,6 +61,7 @@ func init() {
 	flag.StringVar(&flagGenCriteria, "gencriteria", "", "supply name of test (Ex: T1070.004) and the CSV for the criteria will be outputted")
 	flag.StringVar(&flagGenCriteriaOutPath, "outfile", "", "supply name of directory to store generated criteria in csv form (requires gencriteria flag)")
 	flag.StringVar(&flagTidCsvPath, "tidcsvpath", "", "for package mode, a CSV file with testIDs to run in first column")
+	flag.StringVar(&flagLayerPath, "layer", "", "with coverage, path to write ATT&CK Navigator layer json scoring each technique by percentage of tests with criteria")
 }
 
 func ToInt64(valstr string) int64 {
@@ -299,9 +304,49 @@ func FindTestCoverageHelper(dirPath string, atomicMap *map[string][]*types.TestS
 
 	fmt.Printf("%s Criteria coverage : %3.1f %% of %d atomic tests\n", flagPlatform, percentage*100.0, total)
 
+	if len(flagLayerPath) > 0 {
+		layer := CoverageNavigatorLayer(*atomicMap)
+		err = utils.WriteNavigatorLayer(layer, flagLayerPath)
+		if err != nil {
+			fmt.Println("ERROR: unable to write file", flagLayerPath, err)
+		}
+	}
+
 	return percentage
 }
 
+/*
+ * CoverageNavigatorLayer scores each technique by the percentage of its
+ * atomic tests that have criteria, see FindCoverage()
+ */
+func CoverageNavigatorLayer(atomicMap map[string][]*types.TestSpec) *utils.NavigatorLayer {
+	layer := utils.NewNavigatorLayer("atomic-validation-criteria "+flagPlatform, "percentage of atomic tests with validation criteria", flagPlatform)
+	layer.LegendItems = []utils.NavigatorLegendItem{
+		{Label: "all tests have criteria", Color: "#8ec843"},
+		{Label: "no criteria", Color: "#ff6666"},
+	}
+
+	for tid, tests := range atomicMap {
+		if len(tests) == 0 {
+			continue
+		}
+		t := layer.Technique(tid)
+		numCovered := 0
+		for _, test := range tests {
+			status := "no criteria"
+			if gCoveredTests[tid+"#"+test.TestIndex] {
+				numCovered += 1
+				status = "criteria"
+			}
+			t.AddComment(fmt.Sprintf("#%s %s : %s", test.TestIndex, test.TestName, status))
+		}
+		t.AddMetadata("tests", fmt.Sprintf("%d", len(tests)))
+		t.AddMetadata("with_criteria", fmt.Sprintf("%d", numCovered))
+		t.SetScore(math.Round(float64(numCovered) * 100.0 / float64(len(tests))))
+	}
+	return layer
+}
+
 func FindCoverage(filename string, atomicMap map[string][]*types.TestSpec) int {
 	platformName := utils.GetPlatformName()
 
@@ -345,6 +390,7 @@ func FindCoverage(filename string, atomicMap map[string][]*types.TestSpec) int {
 			for _, entry := range atomicMap[cur.Technique] {
 				if len(cur.TestGuid) > 0 && strings.HasPrefix(entry.TestGuid, cur.TestGuid) {
 					criteria += 1
+					gCoveredTests[entry.Technique+"#"+entry.TestIndex] = true
 					break
 				}
 
@@ -355,6 +401,7 @@ func FindCoverage(filename string, atomicMap map[string][]*types.TestSpec) int {
 
 				if cur.TestIndex > 0 && cur.TestIndex == ToUInt(entry.TestIndex) {
 					criteria += 1
+					gCoveredTests[entry.Technique+"#"+entry.TestIndex] = true
 					break
 				}
 
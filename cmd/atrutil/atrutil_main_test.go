@@ -3,6 +3,8 @@ package main
 import (
 	"testing"
 
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,3 +58,25 @@ func TestSplitPipedCmds(t *testing.T) {
 	assert.Equal(t, " grep pa ", a[1])
 	assert.Equal(t, " sort", a[2])
 }
+
+func TestCoverageNavigatorLayer(t *testing.T) {
+	prev := gCoveredTests
+	defer func() { gCoveredTests = prev }()
+
+	gCoveredTests = map[string]bool{"T1053.003#1": true, "T1053.003#3": true, "T1070#1": true}
+	atomicMap := map[string][]*types.TestSpec{
+		"T1053.003": {{Technique: "T1053.003", TestIndex: "1", TestName: "a"}, {Technique: "T1053.003", TestIndex: "2", TestName: "b"}, {Technique: "T1053.003", TestIndex: "3", TestName: "c"}, {Technique: "T1053.003", TestIndex: "4", TestName: "d"}},
+		"T1070":     {{Technique: "T1070", TestIndex: "1", TestName: "e"}},
+		"T1082":     {{Technique: "T1082", TestIndex: "1", TestName: "f"}},
+	}
+	layer := CoverageNavigatorLayer(atomicMap)
+
+	assert.Equal(t, 50.0, *layer.Technique("T1053.003").Score)
+	assert.Equal(t, "#1 a : criteria\n#2 b : no criteria\n#3 c : criteria\n#4 d : no criteria", layer.Technique("T1053.003").Comment)
+	assert.Equal(t, 100.0, *layer.Technique("T1070").Score)
+	assert.Equal(t, 0.0, *layer.Technique("T1082").Score)
+
+	// parent of sub-technique is shown expanded, without score
+	assert.True(t, layer.Technique("T1053").ShowSubtechniques)
+	assert.Nil(t, layer.Technique("T1053").Score)
+}
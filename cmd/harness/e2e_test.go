@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTool(t *testing.T, binDir string, pkgDir string) string {
+	outPath := filepath.Join(binDir, filepath.Base(pkgDir))
+	cmd := exec.Command("go", "build", "-o", outPath, pkgDir)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to build %s: %v\n%s", pkgDir, err, output)
+	}
+	return outPath
+}
+
+/*
+ * resetHarnessState clears globals that main() fills in, and restores
+ * flags when test completes.
+ */
+func resetHarnessState(t *testing.T) {
+	prevAtomics, prevCriteria, prevGoArt, prevResults := flagAtomicsPath, flagCriteriaPath, flagGoArtRunnerPath, flagResultsPath
+	prevTools := gTelemTools
+	t.Cleanup(func() {
+		flagAtomicsPath, flagCriteriaPath, flagGoArtRunnerPath, flagResultsPath = prevAtomics, prevCriteria, prevGoArt, prevResults
+		gTelemTools = prevTools
+	})
+
+	gTestSpecs = []*types.TestSpec{}
+	gRecs = []*types.AtomicTestCriteria{}
+	gAtomicTests = map[string][]*types.TestSpec{}
+	gTechniquesMissingTests = []string{}
+	gKeepRunning = true
+}
+
+func loadStatus(t *testing.T, resultsDir string) map[string]types.TestProgress {
+	data, err := os.ReadFile(filepath.Join(resultsDir, "status.json"))
+	assert.Nil(t, err)
+	progress := []types.TestProgress{}
+	assert.Nil(t, json.Unmarshal(data, &progress))
+
+	retval := map[string]types.TestProgress{}
+	for _, entry := range progress {
+		retval[entry.Technique+"_"+entry.TestIndex] = entry
+	}
+	return retval
+}
+
+func assertReplayResults(t *testing.T, resultsDir string) {
+	status := loadStatus(t, resultsDir)
+	assert.Equal(t, types.StatusValidateSuccess, status["T0001_1"].Status)
+	assert.Equal(t, types.StatusValidatePartial, status["T0001_2"].Status)
+
+	data, err := os.ReadFile(filepath.Join(resultsDir, "T0001_1", "match_string.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "PF", string(data))
+	data, err = os.ReadFile(filepath.Join(resultsDir, "T0001_2", "match_string.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "P<F>", string(data))
+
+	// native events of matches
+	data, err = os.ReadFile(filepath.Join(resultsDir, "T0001_1", "matches.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "\"native_id\":2")
+}
+
+/*
+ * Runs the harness with goartrun and telemtool-replay serving
+ * telemetry from testdata/e2e/fixtures, then revalidates results.
+ */
+func TestReplayEndToEnd(t *testing.T) {
+	if testing.Short() || runtime.GOOS == "windows" {
+		t.Skip("builds and runs goartrun and telemtool-replay")
+	}
+
+	// goart stage regex expects working dirs in /tmp
+	t.Setenv("TMPDIR", "/tmp")
+
+	testdata, err := filepath.Abs("testdata/e2e")
+	assert.Nil(t, err)
+	t.Setenv("TELEMTOOL_REPLAY_FIXTURES", filepath.Join(testdata, "fixtures"))
+
+	binDir := t.TempDir()
+	goartPath := buildTool(t, binDir, "../goartrun")
+	replayPath := buildTool(t, binDir, "../telemtool-replay")
+
+	resetHarnessState(t)
+	flagAtomicsPath = filepath.Join(testdata, "atomics")
+	flagCriteriaPath = filepath.Join(testdata, "criteria")
+	flagGoArtRunnerPath = goartPath
+	flagResultsPath = t.TempDir()
+	gTelemTools = PrepTelemTools(replayPath)
+
+	assert.Nil(t, utils.LoadAtomicsIndexCsvPlatform(flagAtomicsPath, &gAtomicTests, "linux"))
+	assert.True(t, LoadCriteriaFiles(flagCriteriaPath, &gAtomicTests))
+	assert.True(t, ParseTestSpecs([]string{"T0001"}))
+	assert.True(t, FindCriteriaForTestSpecs())
+	assert.False(t, MissingCmdlineArgs())
+
+	CallTelemetryPrepare(false)
+	RunTests()
+
+	assertReplayResults(t, flagResultsPath)
+
+	// revalidate using telemetry fetched above, with fresh criteria
+
+	resultsDir := flagResultsPath
+	os.Remove(filepath.Join(resultsDir, "T0001_1", "match_string.txt"))
+	os.Remove(filepath.Join(resultsDir, "T0001_2", "match_string.txt"))
+
+	resetHarnessState(t)
+	assert.Nil(t, utils.LoadAtomicsIndexCsvPlatform(flagAtomicsPath, &gAtomicTests, "linux"))
+	assert.True(t, LoadCriteriaFiles(flagCriteriaPath, &gAtomicTests))
+	LoadSpecsForRevalidate(resultsDir, &gTestSpecs)
+	assert.Equal(t, 2, len(gTestSpecs))
+	assert.True(t, FindCriteriaForTestSpecs())
+
+	Revalidate(resultsDir)
+
+	assertReplayResults(t, resultsDir)
+}
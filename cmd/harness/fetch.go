@@ -0,0 +1,250 @@
+package main
+
+/*
+ * Per-test telemetry fetch and validation, see --fetchmode test
+ *
+ * After each test runs, it is queued to the fetcher.  Once the test
+ * ended --telemetrygrace seconds ago, telemetry for the time range of
+ * the test is fetched into its results dir and validated, and status
+ * files are updated.  Tests keep running meanwhile.
+ *
+ * With --telemetrytimeout, the fetcher polls instead: fetching and
+ * validating again with backoff until coverage is 1.0, or the timeout
+ * after end of test, or the --telemetrydeadline of the run is reached.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+var kPollInitialInterval = 2 * time.Second
+var kPollMaxInterval = 30 * time.Second
+var kPollCheckInterval = 250 * time.Millisecond // to notice interrupt while waiting
+
+type TestFetcher struct {
+	queue    chan *SingleTestRun
+	wg       sync.WaitGroup
+	testRuns func() []*SingleTestRun // all tests, for SaveState()
+	deadline time.Time               // --telemetrydeadline, or zero
+}
+
+/*
+ * TestPoll is the fetch schedule of a test
+ */
+type TestPoll struct {
+	testRun  *SingleTestRun
+	next     time.Time
+	interval time.Duration
+	deadline time.Time // last fetch is at or after this
+	numPolls int
+
+	firstMatchTimes  map[string]int64 // by tool key and expected event id, wall time in ns
+	fullCoverageTime map[string]int64 // by tool key
+}
+
+var gTestFetcher *TestFetcher // set by RunTests() with --fetchmode test
+
+/*
+ * NewTestFetcher starts the fetcher goroutine.  maxTests is the most
+ * tests that will be queued, so Add() does not block.
+ */
+func NewTestFetcher(maxTests int, testRuns func() []*SingleTestRun) *TestFetcher {
+	f := &TestFetcher{queue: make(chan *SingleTestRun, maxTests), testRuns: testRuns}
+	if flagTelemetryDeadline > 0 {
+		f.deadline = time.Now().Add(time.Duration(flagTelemetryDeadline) * time.Second)
+	}
+	f.wg.Add(1)
+	go f.run()
+	return f
+}
+
+/*
+ * Add queues a test that ran successfully to be fetched and validated
+ */
+func (f *TestFetcher) Add(testRun *SingleTestRun) {
+	SetTestState(testRun, types.StateWaitForTelemetry)
+	f.queue <- testRun
+}
+
+/*
+ * Close waits for queued tests to be validated.  If interrupted, the
+ * remaining tests are fetched once without waiting.
+ */
+func (f *TestFetcher) Close() {
+	close(f.queue)
+	f.wg.Wait()
+}
+
+/*
+ * NewTestPoll schedules the first fetch of test.  Without polling,
+ * it is also the last, after the grace period.
+ */
+func (f *TestFetcher) NewTestPoll(testRun *SingleTestRun) *TestPoll {
+	endTime := time.Now()
+	if testRun.EndTime > 0 {
+		endTime = time.Unix(0, testRun.EndTime)
+	}
+	p := &TestPoll{testRun: testRun, interval: kPollInitialInterval, firstMatchTimes: map[string]int64{}, fullCoverageTime: map[string]int64{}}
+	if flagTelemetryTimeout > 0 {
+		p.next = endTime.Add(kPollInitialInterval)
+		p.deadline = endTime.Add(time.Duration(flagTelemetryTimeout) * time.Second)
+	} else {
+		p.next = endTime.Add(time.Duration(flagTelemetryGrace) * time.Second)
+		p.deadline = p.next
+	}
+	if !f.deadline.IsZero() && f.deadline.Before(p.deadline) {
+		p.deadline = f.deadline
+	}
+	if p.deadline.Before(p.next) {
+		p.next = p.deadline
+	}
+	return p
+}
+
+func (f *TestFetcher) run() {
+	defer f.wg.Done()
+
+	active := []*TestPoll{}
+	queue := f.queue
+	for queue != nil || len(active) > 0 {
+
+		// wait for a new test or the next fetch that is due
+
+		var timer <-chan time.Time
+		if len(active) > 0 {
+			sort.SliceStable(active, func(i, j int) bool { return active[i].next.Before(active[j].next) })
+			wait := time.Until(active[0].next)
+			if !gKeepRunning || wait < 0 {
+				wait = 0
+			} else if wait > kPollCheckInterval {
+				wait = kPollCheckInterval
+			}
+			timer = time.After(wait)
+		}
+
+		select {
+		case testRun, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			active = append(active, f.NewTestPoll(testRun))
+		case <-timer:
+			p := active[0]
+			if gKeepRunning && time.Now().Before(p.next) {
+				continue
+			}
+			if f.Poll(p) {
+				active = active[1:]
+			}
+			SaveState(f.testRuns())
+		}
+	}
+}
+
+/*
+ * Poll fetches and validates telemetry of test, and schedules the next
+ * fetch with backoff.  Returns true when test is done: full coverage,
+ * deadline reached or interrupted.
+ */
+func (f *TestFetcher) Poll(p *TestPoll) bool {
+	testRun := p.testRun
+	p.numPolls += 1
+	now := time.Now()
+	isLast := !gKeepRunning || !now.Before(p.deadline)
+
+	if !FetchAndValidateTestRun(testRun) {
+		return true
+	}
+
+	gStateLock.Lock()
+	isCovered := RecordLatency(p, now.UnixNano())
+	if isCovered || isLast {
+		testRun.state = types.StateDone
+	}
+	WriteTestRunStatusFile(testRun)
+	gStateLock.Unlock()
+
+	if isCovered || isLast {
+		return true
+	}
+
+	p.next = now.Add(p.interval)
+	if p.next.After(p.deadline) {
+		p.next = p.deadline
+	}
+	p.interval *= 2
+	if p.interval > kPollMaxInterval {
+		p.interval = kPollMaxInterval
+	}
+	if gVerbose {
+		fmt.Println("telemetry incomplete for", testRun.criteria.Technique, testRun.criteria.TestIndex, "next fetch in", time.Until(p.next))
+	}
+	return false
+}
+
+/*
+ * RecordLatency sets the time to first match of each expected event,
+ * and the time to full coverage of each tool, which is when its last
+ * required expected event was first matched, measured at fetch time
+ * now, and saves validate_summary again.  Returns true if coverage is
+ * 1.0, of any tool with --combine any, or of all tools otherwise.
+ */
+func RecordLatency(p *TestPoll, now int64) bool {
+	numCovered := 0
+	for _, v := range p.testRun.validators {
+		key := v.tool.Key()
+		for _, exp := range v.State.TestData.ExpectedEvents {
+			if len(exp.Matches) == 0 {
+				continue
+			}
+			firstMatchTime, ok := p.firstMatchTimes[key+"#"+exp.Id]
+			if !ok {
+				firstMatchTime = now
+				p.firstMatchTimes[key+"#"+exp.Id] = now
+			}
+			if ts := exp.Matches[0].Timestamp; ts > 0 && firstMatchTime > ts {
+				exp.TimeToFirstMatchMs = (firstMatchTime - ts) / int64(time.Millisecond)
+			}
+		}
+
+		if v.State.Coverage >= 1.0 {
+			numCovered += 1
+			if _, ok := p.fullCoverageTime[key]; !ok {
+				p.fullCoverageTime[key] = now
+			}
+		}
+		if t, ok := p.fullCoverageTime[key]; ok && p.testRun.EndTime > 0 && t > p.testRun.EndTime {
+			v.State.TimeToFullCoverageMs = (t - p.testRun.EndTime) / int64(time.Millisecond)
+		}
+		v.State.NumPolls = p.numPolls
+		WriteValidateSummary(v)
+	}
+	if "any" == flagCombine {
+		return numCovered > 0
+	}
+	return numCovered > 0 && numCovered == len(p.testRun.validators)
+}
+
+/*
+ * FetchAndValidateTestRun fetches telemetry of the time range of test,
+ * padded by a second, into its results dir and validates it.  Returns
+ * false if test has no time range.
+ */
+func FetchAndValidateTestRun(testRun *SingleTestRun) bool {
+	if 0 == testRun.StartTime || 0 == testRun.EndTime {
+		fmt.Println("ERROR: no start and end time of test in run_summary, unable to fetch telemetry", testRun.resultsDir)
+		SetTestState(testRun, types.StateDone)
+		return false
+	}
+	FetchTelemetry(testRun.resultsDir, testRun.StartTime/int64(time.Second)-1, testRun.EndTime/int64(time.Second)+1)
+
+	// status of test is updated under gStateLock once validated
+	ValidateTestRuns([]*SingleTestRun{testRun}, gTelemTools, testRun.resultsDir)
+	return true
+}
@@ -17,6 +17,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,15 +37,15 @@ type SingleTestRun struct {
 	resultsDir string
 	workingDir string
 
+	runConfig           string // runspec JSON, or path to it on windows
+	isElevationRequired bool
+
 	StartTime int64 // timestamps returned by goartrun for test
 	EndTime   int64
 
-	TimeOfParentShell int64 // determined using IsGoArtStage()
-	TimeOfNextStage   int64
-	ShellPid          int64
-	TimeWorkDirCreate int64
-	TimeWorkDirDelete int64
-	HasMitreTag       bool
+	HasMitreTag bool // set if telemetry of any tool has matching technique tag
+
+	validators []*Validator // one per telemetry tool, see ValidateTestRuns()
 }
 
 type TelemTool struct {
@@ -53,6 +54,17 @@ type TelemTool struct {
 	Suffix string // _e2e
 }
 
+/*
+ * Key identifies the tool in per-tool status and columns. The
+ * suffix, or name if tool has no suffix.
+ */
+func (t *TelemTool) Key() string {
+	if len(t.Suffix) > 0 {
+		return t.Suffix
+	}
+	return t.Name
+}
+
 var AtomicsFolderRegex = regexp.MustCompile(`PathToAtomicsFolder(\\|\/)`)
 
 var kTestRunTimeoutSeconds = 10 * time.Second
@@ -74,6 +86,15 @@ var flagClearTelemetryCache bool
 var flagFilterByGoartrunShell bool
 var flagFilterFileEventsTmp bool
 var flagTimeout int64
+var flagParallel int
+var flagAttribution string
+var flagValidateWorkers int
+var flagCombine string
+var flagFetchMode string
+var flagTelemetryGrace int
+var flagTelemetryTimeout int
+var flagTelemetryDeadline int
+var flagReport string
 
 var gTestSpecs []*types.TestSpec = []*types.TestSpec{}
 var gRecs []*types.AtomicTestCriteria = []*types.AtomicTestCriteria{} // our detection rules
@@ -87,6 +108,7 @@ var gFlagNoRun = false
 var gKeepRunning = true
 var gAtomicTests = map[string][]*types.TestSpec{} // tid -> tests
 var gTelemTools = []*TelemTool{}
+var gStateLock sync.Mutex // guards SingleTestRun state and status files when running in parallel
 
 func init() {
 	flag.StringVar(&flagCriteriaPath, "criteriapath", "", "path to folder containing CSV files used to validate telemetry")
@@ -108,6 +130,15 @@ func init() {
 	flag.BoolVar(&flagFilterByGoartrunShell, "filtergoartsh", true, "if true, do not validate events before/after goartrun test shell")
 	flag.BoolVar(&flagFilterFileEventsTmp, "filtergoartdir", true, "if true, do not validate events before/after create and delete of goartrun working dir. Working dir is in /tmp, so if that is not in the file monitoring paths of endpoint agent, set this to false.")
 	flag.Int64Var(&flagTimeout, "timeout", 30, "timeout duration in seconds")
+	flag.StringVar(&flagAttribution, "attribution", "time", "how events are attributed to a test: time (goartrun shell and working dir time windows), tree (test shell process and descendants), or both. Defaults to both when --parallel > 1")
+	flag.IntVar(&flagParallel, "parallel", 1, "number of tests to run concurrently. Tests needing elevation or sharing file paths, process exes or netflow ports in criteria are run serially")
+	flag.StringVar(&flagCombine, "combine", "all", "how status from multiple telemetry tools is combined: any (best of tools), all (worst of tools), or columns (worst, plus a status column per tool in summary)")
+	flag.StringVar(&flagFetchMode, "fetchmode", "run", "when telemetry is fetched: run (once after all tests) or test (after each test, validating as tests complete)")
+	flag.IntVar(&flagTelemetryGrace, "telemetrygrace", kWaitTelemetrySeconds, "with --fetchmode test, seconds to wait after a test ends for its telemetry to arrive")
+	flag.IntVar(&flagTelemetryTimeout, "telemetrytimeout", 0, "poll telemetry of each test with backoff until coverage is 1.0 or this many seconds after the test ends. Implies --fetchmode test")
+	flag.IntVar(&flagTelemetryDeadline, "telemetrydeadline", 0, "with --telemetrytimeout, stop polling this many seconds after tests start running")
+	flag.StringVar(&flagReport, "report", "", "comma-separated reports to write into results dir: junit (junit.xml), html (index.html)")
+	flag.IntVar(&flagValidateWorkers, "validateworkers", 0, "number of concurrent validation workers. Default 0 uses one per telemetry tool. More workers than tools split the tests into shards, each reading the telemetry files")
 }
 
 /*
@@ -492,18 +523,32 @@ func SubstituteVarsInCriteria(criteria *types.AtomicTestCriteria, args map[strin
 				}
 			}
 		}
+		for _, alert := range criteria.ExpectedAlerts {
+			for j, f := range alert.FieldChecks {
+				alert.FieldChecks[j].Value = strings.ReplaceAll(f.Value, needle, val)
+			}
+			for j, keyword := range alert.Keywords {
+				alert.Keywords[j] = strings.ReplaceAll(keyword, needle, val)
+			}
+		}
 	}
 
 	// TODO: check for special items like $HOME (different on linux,macos) and privilege level
 
 	// run through again to see if any remain
 
+	// patterns are compiled again with substituted values
+
 	for _, exp := range criteria.ExpectedEvents {
-		for _, f := range exp.FieldChecks {
+		for j, f := range exp.FieldChecks {
 			if VarSubRegex.MatchString(f.Value) {
 				fmt.Println("MISSING criteria variable", f.Value)
 				return false
 			}
+			if err := utils.CompileFieldCriteria(&exp.FieldChecks[j]); err != nil {
+				fmt.Println("ERROR:", err)
+				return false
+			}
 		}
 		if strings.ToUpper(exp.EventType) == "NETFLOW" {
 			if VarSubRegex.MatchString(exp.SubType) {
@@ -511,6 +556,22 @@ func SubstituteVarsInCriteria(criteria *types.AtomicTestCriteria, args map[strin
 				return false
 			}
 		}
+		if err := utils.CompileSubType(exp); err != nil {
+			fmt.Println("ERROR:", err)
+			return false
+		}
+	}
+	for _, alert := range criteria.ExpectedAlerts {
+		for j, f := range alert.FieldChecks {
+			if VarSubRegex.MatchString(f.Value) {
+				fmt.Println("MISSING criteria variable", f.Value)
+				return false
+			}
+			if err := utils.CompileFieldCriteria(&alert.FieldChecks[j]); err != nil {
+				fmt.Println("ERROR:", err)
+				return false
+			}
+		}
 	}
 	return true
 }
@@ -525,11 +586,17 @@ func CallTelemetryPrepare(doClearCache bool) {
 
 	for _, tool := range gTelemTools {
 
-		suffix := tool.Suffix
-		if len(suffix) == 0 {
-			suffix = "''"
+		// an empty arg would end flag parsing of the tool
+
+		args := []string{"--prepare"}
+		if len(clearArg) > 0 {
+			args = append(args, clearArg)
+		}
+		args = append(args, "--resultsdir", resultsDir)
+		if len(tool.Suffix) != 0 {
+			args = append(args, "--suffix", tool.Suffix)
 		}
-		cmd := exec.Command(tool.Path, "--prepare", clearArg, "--resultsdir", resultsDir, "--suffix", suffix)
+		cmd := exec.Command(tool.Path, args...)
 
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -542,6 +609,20 @@ func CallTelemetryPrepare(doClearCache bool) {
 	}
 }
 
+/*
+ * HasTestTelemetry returns true if telemetry was fetched into the
+ * results dir of test, see --fetchmode test
+ */
+func HasTestTelemetry(testRun *SingleTestRun) bool {
+	for _, tool := range gTelemTools {
+		_, err := os.Stat(filepath.Join(testRun.resultsDir, "simple_telemetry"+tool.Suffix+".json"))
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func FetchTelemetry(resultsDir string, startTime, endTime int64) {
 
 	for _, tool := range gTelemTools {
@@ -579,19 +660,14 @@ func FetchTelemetry(resultsDir string, startTime, endTime int64) {
 }
 
 func UpdateTimestampsFromRunSummary(testRun *SingleTestRun) {
-	path := filepath.FromSlash(testRun.resultsDir + "/run_summary.json")
-	data, err := os.ReadFile(path)
+	results := &types.ScriptResults{}
+	err := utils.LoadRunSummary(testRun.resultsDir, results)
 	if err != nil {
 		if gVerbose {
 			fmt.Println("unable to read run_summary", err)
 		}
 		return
 	}
-	results := &types.ScriptResults{}
-	if err = json.Unmarshal(data, results); err != nil {
-		fmt.Println("Error parsing run_summary.json", path, err)
-		return
-	}
 
 	testRun.StartTime = results.StartTime
 	testRun.EndTime = results.EndTime
@@ -612,7 +688,7 @@ func GoArtRunTestWin(testRun *SingleTestRun, runSpecJson string) {
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		fmt.Errorf("  runner error: %w", err)
+		fmt.Println("  runner error:", err)
 	} else {
 		fmt.Println("  runner finished without error")
 	}
@@ -625,8 +701,10 @@ func GoArtRunTestWin(testRun *SingleTestRun, runSpecJson string) {
 		fmt.Println("ERROR: unable to write file", outPath, err)
 	}
 
+	gStateLock.Lock()
 	testRun.exitCode = cmd.ProcessState.ExitCode()
 	testRun.status = types.TestStatus(testRun.exitCode)
+	gStateLock.Unlock()
 	fmt.Printf("runner exited with code %d %s\n", testRun.exitCode, testRun.status)
 }
 
@@ -639,7 +717,7 @@ func GoArtRunTest(testRun *SingleTestRun, runSpecJson string) {
 
 	dest, err := cmd.StdinPipe()
 	if err != nil {
-		fmt.Errorf("executing runner: %w", err)
+		fmt.Println("executing runner:", err)
 		return
 	}
 	_, err = io.WriteString(dest, runSpecJson)
@@ -651,7 +729,7 @@ func GoArtRunTest(testRun *SingleTestRun, runSpecJson string) {
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		fmt.Errorf("  runner error: %w", err)
+		fmt.Println("  runner error:", err)
 	} else {
 		fmt.Println("  runner finished without error")
 	}
@@ -664,8 +742,10 @@ func GoArtRunTest(testRun *SingleTestRun, runSpecJson string) {
 		fmt.Println("ERROR: unable to write file", outPath, err)
 	}
 
+	gStateLock.Lock()
 	testRun.exitCode = cmd.ProcessState.ExitCode()
 	testRun.status = types.TestStatus(testRun.exitCode)
+	gStateLock.Unlock()
 	fmt.Printf("runner exited with code %d %s\n", testRun.exitCode, testRun.status)
 }
 
@@ -762,14 +842,27 @@ func WriteTestRunStatusFile(testRun *SingleTestRun) {
 
 	// load match string written by telemetry tool and update testRun object
 
-	inPath := filepath.FromSlash(testRun.resultsDir + "/match_string.txt")
-	matchString, _ := os.ReadFile(inPath)
-	testRun.matchString = string(matchString)
+	if len(testRun.validators) > 0 {
+		a := []string{}
+		for _, v := range testRun.validators {
+			a = append(a, v.MatchString())
+		}
+		testRun.matchString = strings.Join(a, " ")
+	} else {
+		inPath := filepath.FromSlash(testRun.resultsDir + "/match_string.txt")
+		matchString, _ := os.ReadFile(inPath)
+		testRun.matchString = string(matchString)
+	}
 
-	// save status file
+	// save status file, followed by a line per tool if more than one
 
 	outPath := filepath.FromSlash(testRun.resultsDir + "/status.txt")
 	s := fmt.Sprintf("%d\n%s", testRun.status, testRun.status)
+	if len(testRun.validators) > 1 {
+		for _, v := range testRun.validators {
+			s += fmt.Sprintf("\n%s %d %s %s", v.tool.Key(), v.Status, v.Status, v.MatchString())
+		}
+	}
 	err := os.WriteFile(outPath, []byte(s), 0644)
 	if err != nil {
 		fmt.Println("ERROR: unable to write file", outPath, err)
@@ -782,13 +875,33 @@ func MarkAsSkipped(testRun *SingleTestRun) {
 	WriteTestRunStatusFile(testRun)
 }
 
-func SaveState(tests []*SingleTestRun) {
-
+/*
+ * GetTestProgress returns the status.json entries of tests
+ */
+func GetTestProgress(tests []*SingleTestRun) []types.TestProgress {
 	progress := []types.TestProgress{}
 	for _, t := range tests {
-		obj := types.TestProgress{t.criteria.Technique, fmt.Sprintf("%d", t.criteria.TestIndex), t.criteria.TestName, t.criteria.TestGuid, t.state, t.exitCode, t.status}
+		obj := types.TestProgress{Technique: t.criteria.Technique, TestIndex: fmt.Sprintf("%d", t.criteria.TestIndex), TestName: t.criteria.TestName, TestGuid: t.criteria.TestGuid, State: t.state, ExitCode: t.exitCode, Status: t.status}
+		obj.MatchString = t.matchString
+		obj.NumAlertsDetected, obj.NumAlerts = CombineDetections(t)
+		if len(t.validators) > 1 {
+			obj.ToolStatus = map[string]types.TestStatus{}
+			obj.ToolMatchStrings = map[string]string{}
+			for _, v := range t.validators {
+				obj.ToolStatus[v.tool.Key()] = v.Status
+				obj.ToolMatchStrings[v.tool.Key()] = v.MatchString()
+			}
+		}
 		progress = append(progress, obj)
 	}
+	return progress
+}
+
+func SaveState(tests []*SingleTestRun) {
+	gStateLock.Lock()
+	defer gStateLock.Unlock()
+
+	progress := GetTestProgress(tests)
 	j, err := json.MarshalIndent(progress, "", "  ")
 	if err != nil {
 		fmt.Println("ERROR:", err)
@@ -821,6 +934,9 @@ func SPrintState(tests []*SingleTestRun, byCategory bool) string {
 	numSkipped := 0
 	numRunErrors := 0
 	numMissingDeps := 0
+	numUnexpected := 0
+	numDetected := 0
+	numUndetected := 0
 
 	s := ""
 	for _, tid := range gTechniquesMissingTests {
@@ -837,6 +953,8 @@ func SPrintState(tests []*SingleTestRun, byCategory bool) string {
 			numValidateFail += 1
 		case types.StatusValidatePartial:
 			numPartial += 1
+		case types.StatusValidateUnexpected:
+			numUnexpected += 1
 		case types.StatusPreReqFail:
 			numMissingDeps += 1
 		case types.StatusSkipped:
@@ -845,8 +963,19 @@ func SPrintState(tests []*SingleTestRun, byCategory bool) string {
 			numRunErrors += 1
 		}
 
+		detected, numAlerts := CombineDetections(t)
+		detections := ""
+		if numAlerts > 0 && t.status >= types.StatusTestSuccess {
+			detections = fmt.Sprintf("Detect:%d/%d ", detected, numAlerts)
+			if detected == numAlerts {
+				numDetected += 1
+			} else {
+				numUndetected += 1
+			}
+		}
+
 		strState := fmt.Sprintf("%s%s", t.state, t.status)
-		line := fmt.Sprintf("-%9s %2d %s %-12s %-16s \"%s\"\n", t.criteria.Technique, t.criteria.TestIndex, t.state, t.status, t.matchString, t.criteria.TestName)
+		line := fmt.Sprintf("-%9s %2d %s %-12s %s%s\"%s\"\n", t.criteria.Technique, t.criteria.TestIndex, t.state, t.status, SPrintToolColumns(t), detections, t.criteria.TestName)
 		a, ok := byState[strState]
 		if !ok {
 			a = []string{}
@@ -861,9 +990,43 @@ func SPrintState(tests []*SingleTestRun, byCategory bool) string {
 		}
 	}
 
-	s += fmt.Sprintf("=== Validated:%d Partial:%d NoTelemetry:%d Skipped:%d RunErrors:%d MissingDeps:%d NoTests:%d\n",
-		numValidated, numPartial, numValidateFail, numSkipped, numRunErrors, numMissingDeps, len(gTechniquesMissingTests))
+	if len(gTelemTools) > 1 {
+		keys := []string{}
+		for _, tool := range gTelemTools {
+			keys = append(keys, tool.Key())
+		}
+		s += fmt.Sprintf("=== Tools:%s Combine:%s\n", strings.Join(keys, ","), flagCombine)
+	}
+	if numDetected+numUndetected > 0 {
+		s += fmt.Sprintf("=== Detected:%d Undetected:%d\n", numDetected, numUndetected)
+	}
+	s += fmt.Sprintf("=== Validated:%d Partial:%d NoTelemetry:%d Unexpected:%d Skipped:%d RunErrors:%d MissingDeps:%d NoTests:%d\n",
+		numValidated, numPartial, numValidateFail, numUnexpected, numSkipped, numRunErrors, numMissingDeps, len(gTechniquesMissingTests))
+
+	return s
+}
 
+/*
+ * SPrintToolColumns returns the match string column of summary line, or
+ * a column per telemetry tool when there are more than one.  With
+ * --combine columns, the status from each tool precedes its match string.
+ */
+func SPrintToolColumns(t *SingleTestRun) string {
+	if len(gTelemTools) <= 1 {
+		return fmt.Sprintf("%-16s ", t.matchString)
+	}
+	s := ""
+	for i := range gTelemTools {
+		status, matchString := "", ""
+		if i < len(t.validators) {
+			status = t.validators[i].Status.String()
+			matchString = t.validators[i].MatchString()
+		}
+		if "columns" == flagCombine {
+			s += fmt.Sprintf("%-12s ", status)
+		}
+		s += fmt.Sprintf("%-16s ", matchString)
+	}
 	return s
 }
 
@@ -940,6 +1103,7 @@ func LoadFile(filename string, atomicMap *map[string][]*types.TestSpec) error {
 		log.Fatal(err)
 	}
 
+	numRecs := len(gRecs)
 	for _, row := range records {
 
 		if 3 != len(row[0]) {
@@ -967,16 +1131,33 @@ func LoadFile(filename string, atomicMap *map[string][]*types.TestSpec) error {
 		} else {
 			switch row[0] {
 			case "_E_":
-				evt := utils.EventFromRow(len(cur.ExpectedEvents), row)
+				evt, err := utils.EventFromRow(len(cur.ExpectedEvents), row)
+				CheckCriteriaError(cur, err)
 				//fmt.Println("_E_", evt)
 				cur.ExpectedEvents = append(cur.ExpectedEvents, &evt)
 			case "_?_":
-				evt := utils.EventFromRow(len(cur.ExpectedEvents), row)
+				evt, err := utils.EventFromRow(len(cur.ExpectedEvents), row)
+				CheckCriteriaError(cur, err)
 				evt.IsMaybe = true
 				//fmt.Println("_E_", evt)
 				cur.ExpectedEvents = append(cur.ExpectedEvents, &evt)
+			case "_N_":
+				evt, err := utils.EventFromRow(len(cur.ExpectedEvents), row)
+				CheckCriteriaError(cur, err)
+				evt.IsNegated = true
+				cur.ExpectedEvents = append(cur.ExpectedEvents, &evt)
 			case "_C_":
-				cur.ExpectedCorrelations = append(cur.ExpectedCorrelations, utils.CorrelationFromRow(row))
+				corr, err := utils.CorrelationFromRow(len(cur.ExpectedCorrelations), row)
+				CheckCriteriaError(cur, err)
+				cur.ExpectedCorrelations = append(cur.ExpectedCorrelations, &corr)
+			case "_A_":
+				if len(row) < 2 {
+					fmt.Println("ERROR: Expected type for _A_ row", row)
+					continue
+				}
+				alert, err := utils.AlertFromRow(len(cur.ExpectedAlerts), row)
+				CheckCriteriaError(cur, err)
+				cur.ExpectedAlerts = append(cur.ExpectedAlerts, &alert)
 			case "ARG":
 				cur.Args[row[1]] = row[2]
 			case "FYI":
@@ -988,9 +1169,27 @@ func LoadFile(filename string, atomicMap *map[string][]*types.TestSpec) error {
 			}
 		}
 	}
+
+	// _C_ rows may come before the _E_ rows they reference
+	for _, rec := range gRecs[numRecs:] {
+		CheckCriteriaError(rec, utils.CheckCorrelationIndexes(rec))
+	}
 	return nil
 }
 
+/*
+ * CheckCriteriaError reports an invalid criteria row as load-time error,
+ * and adds it to Warnings, so the test is skipped rather than validated
+ * with missing checks.
+ */
+func CheckCriteriaError(criteria *types.AtomicTestCriteria, err error) {
+	if err == nil {
+		return
+	}
+	fmt.Println("ERROR:", criteria.Technique, criteria.TestIndex, err)
+	criteria.Warnings = append(criteria.Warnings, err.Error())
+}
+
 func LoadTechniquesList(filename string) error {
 	filename = filepath.FromSlash(filename)
 	data, err := ioutil.ReadFile(filename)
@@ -1170,136 +1369,294 @@ func interpolateWithArgs(interpolatee string, atomic *types.AtomicTest, args map
 	return interpolated
 }
 
-func RunTests() {
-	numTestsRun := 0
-	testRuns := []*SingleTestRun{}
+/*
+ * Loads the atomic for the test, substitutes args and writes the runspec.
+ * On success, testRun.runConfig is set and the test is ready to run.
+ * @return false if test was skipped
+ */
+func PrepareTestRun(testRun *SingleTestRun, spec *types.TestSpec, testRuns []*SingleTestRun) bool {
+	rec := testRun.criteria
+	var err error
+
+	// load atomic to get default args
+	atomic,testIndex := LoadAtomic(rec.Technique, rec.TestIndex, rec.TestGuid, filepath.FromSlash(flagAtomicsPath), gVerbose)
+	if atomic != nil {
+		err = checkPlatform(atomic)
+		if err != nil {
+			fmt.Println("ERROR: Unable to find atomic test for ", rec)
+		} else {
+			FillArgDefaults(atomic, rec, filepath.FromSlash(flagAtomicsPath))
+		}
+	}
 
-	startTime := time.Now().Unix()
+	if atomic == nil || ShouldBeSkipped(rec) {
+		fmt.Println("Test Warning - skipping", testRun.criteria.Technique, testRun.criteria.TestName)
+		if len(testRun.criteria.Warnings) > 0 {
+			fmt.Println("   " + testRun.criteria.Warnings[0])
+		}
+		MarkAsSkipped(testRun)
+		SaveState(testRuns)
+		return false
+	}
 
-	for _, spec := range gTestSpecs {
+	// Important - criteria most likely specifies the GUID prefix rather than
+	// the test number which can change if a new test is added in middle of yaml.
+	// If guid is in criteria, the testIndex is unset.
+	// So always update criteria with actual test index.
+	// It's used in `validate.go` to find Process event start/end of test.
+	// TODO: criteria.TestIndex should be renamed to TestNum!!
 
-		for _, rec := range spec.Criteria {
+	testRun.criteria.TestIndex = uint(testIndex + 1)
 
-			testRun := &SingleTestRun{}
-			testRun.criteria = rec
-			testRun.state = types.StateCriteriaLoaded
-			testRuns = append(testRuns, testRun)
+	resultsDir := filepath.FromSlash(flagResultsPath + "/" + rec.Technique + "_" + fmt.Sprintf("%d", testRun.criteria.TestIndex))
+	testRun.resultsDir = resultsDir
+	err = os.MkdirAll(resultsDir, 0777)
+	if err != nil {
+		fmt.Println("unable to make results dir", err, resultsDir)
+		MarkAsSkipped(testRun)
+		SaveState(testRuns)
+		return false
+	}
 
-			SaveState(testRuns)
-			var err error
+	workingDir, err := os.MkdirTemp("", "artwork-"+spec.Technique+"_"+fmt.Sprintf("%d", testRun.criteria.TestIndex)+"-")
+	if err != nil {
+		fmt.Println("unable to make working dir", err)
+		MarkAsSkipped(testRun)
+		SaveState(testRuns)
+		return false
+	}
 
-			// load atomic to get default args
-			atomic,testIndex := LoadAtomic(rec.Technique, rec.TestIndex, rec.TestGuid, filepath.FromSlash(flagAtomicsPath), gVerbose)
-			if atomic != nil {
-				err = checkPlatform(atomic)
-				if err != nil {
-					fmt.Println("ERROR: Unable to find atomic test for ", rec)
-				} else {
-					FillArgDefaults(atomic, rec, filepath.FromSlash(flagAtomicsPath))
-				}
-			}
+	args := consolidateArgs(atomic, testRun.criteria)
+	testRun.workingDir = workingDir
 
-			if atomic == nil || ShouldBeSkipped(rec) {
-				fmt.Println("Test Warning - skipping", testRun.criteria.Technique, testRun.criteria.TestName)
-				if len(testRun.criteria.Warnings) > 0 {
-					fmt.Println("   " + testRun.criteria.Warnings[0])
-				}
-				MarkAsSkipped(testRun)
-				SaveState(testRuns)
-				continue
-			}
 
-			// Important - criteria most likely specifies the GUID prefix rather than
-			// the test number which can change if a new test is added in middle of yaml.
-			// If guid is in criteria, the testIndex is unset.
-			// So always update criteria with actual test index.
-			// It's used in `validate.go` to find Process event start/end of test.
-			// TODO: criteria.TestIndex should be renamed to TestNum!!
+	// some test Args and field checks need variable substitutions
 
-			testRun.criteria.TestIndex = uint(testIndex + 1)
+	if false == SubstituteSysInfoArgs(testRun.criteria) || false == SubstituteVarsInCriteria(testRun.criteria, args) {
+		MarkAsSkipped(testRun)
+		SaveState(testRuns)
+		return false
+	}
 
-			resultsDir := filepath.FromSlash(flagResultsPath + "/" + rec.Technique + "_" + fmt.Sprintf("%d", testRun.criteria.TestIndex))
-			testRun.resultsDir = resultsDir
-			err = os.MkdirAll(resultsDir, 0777)
-			if err != nil {
-				fmt.Println("unable to make results dir", err, resultsDir)
-				MarkAsSkipped(testRun)
-				SaveState(testRuns)
-				continue
-			}
+	// test script and dependency scripts may need subsitution
 
-			workingDir, err := os.MkdirTemp("", "artwork-"+spec.Technique+"_"+fmt.Sprintf("%d", testRun.criteria.TestIndex)+"-")
-			if err != nil {
-				fmt.Println("unable to make working dir", err)
-				MarkAsSkipped(testRun)
-				SaveState(testRuns)
-				continue
-			}
+	atomic.Executor.Command = interpolateWithArgs(atomic.Executor.Command, atomic, args)
+	atomic.Executor.CleanupCommand = interpolateWithArgs(atomic.Executor.CleanupCommand, atomic, args)
+	for i,_ := range atomic.Dependencies {
+		dep := &atomic.Dependencies[i]
+		dep.PrereqCommand = interpolateWithArgs(dep.PrereqCommand, atomic, args)
+		dep.GetPrereqCommand = interpolateWithArgs(dep.GetPrereqCommand, atomic, args)
+	}
 
-			args := consolidateArgs(atomic, testRun.criteria)
-			testRun.workingDir = workingDir
+	runConfig := BuildRunSpec(atomic, testIndex, testRun.criteria, workingDir, resultsDir)
+	if runConfig == "" {
+		fmt.Println("empty runconfig!, skipping", rec)
+		return false
+	}
+	testRun.runConfig = runConfig
+	testRun.isElevationRequired = atomic.Executor.ElevationRequired
 
+	if runtime.GOOS == "windows" {
+		os.Chmod(workingDir, 0600)
+		os.Chmod(resultsDir, 0600)
+	} else {
+		os.Chmod(workingDir, 0777) // runner cleans up workingDir
+		os.Chmod(resultsDir, 0777)
+	}
+	return true
+}
 
-			// some test Args and field checks need variable substitutions
+/*
+ * Launches goartrun for a prepared test and cleans up the working dir.
+ * Safe to call from multiple goroutines.
+ */
+func ExecuteTestRun(testRun *SingleTestRun, testRuns []*SingleTestRun) {
+	if !gFlagNoRun {
+		SetTestState(testRun, types.StateRunnerLaunched)
+		SaveState(testRuns)
 
-			if false == SubstituteSysInfoArgs(testRun.criteria) || false == SubstituteVarsInCriteria(testRun.criteria, args) {
-				MarkAsSkipped(testRun)
-				SaveState(testRuns)
+		if runtime.GOOS == "windows" {
+			GoArtRunTestWin(testRun, testRun.runConfig)
+		} else {
+			GoArtRunTest(testRun, testRun.runConfig)
+		}
+		SetTestState(testRun, types.StateRunnerFinished)
+
+		UpdateTimestampsFromRunSummary(testRun)
+
+		SaveState(testRuns)
+	}
+
+	// fix permissions after run
+	if runtime.GOOS != "windows" {
+		os.Chmod(testRun.resultsDir, 0755)
+	}
+
+	// runner will try to clean up, but may not be able to with lowered privs
+	err := os.RemoveAll(testRun.workingDir)
+	if err != nil {
+		fmt.Println("Failed to delete working dir", testRun.workingDir, err)
+	}
+
+	if gTestFetcher != nil && testRun.status == types.StatusTestSuccess {
+		gTestFetcher.Add(testRun)
+	}
+}
+
+func SetTestState(testRun *SingleTestRun, state types.TestState) {
+	gStateLock.Lock()
+	testRun.state = state
+	gStateLock.Unlock()
+}
+
+/*
+ * Returns the file paths, process executables and network ports in
+ * field checks of criteria, prefixed by kind, like
+ * "file:/tmp/a", "exe:/usr/bin/crontab" or "port:4444".  Tests sharing
+ * any of these could match each other's events.
+ */
+func GetCriteriaResources(criteria *types.AtomicTestCriteria) []string {
+	resources := []string{}
+	for _, exp := range criteria.ExpectedEvents {
+		eventType := strings.ToUpper(exp.EventType)
+		for _, fc := range exp.FieldChecks {
+			if len(fc.Value) == 0 {
 				continue
 			}
+			prefix := ""
+			switch {
+			case eventType == "FILE" && (fc.FieldName == "path" || fc.FieldName == "target_path" || fc.FieldName == "dest_path"):
+				prefix = "file:"
+			case eventType == "PROCESS" && fc.FieldName == "exepath":
+				prefix = "exe:"
+			case eventType == "NETFLOW" && (fc.FieldName == "dst_port" || fc.FieldName == "src_port"):
+				prefix = "port:"
+			default:
+				continue
+			}
+			resources = append(resources, prefix+fc.Value)
+		}
+	}
+	return resources
+}
 
-			// test script and dependency scripts may need subsitution
+/*
+ * Splits prepared tests into ones that can run concurrently, and ones
+ * that must be run serially.  Tests that need elevation, or whose criteria
+ * reference the same file path, process exe or network port as another
+ * test, are serial.  Other overlaps, like a process cmdline or a
+ * netflow host, are not detected.
+ */
+func PartitionParallelTests(tests []*SingleTestRun) ([]*SingleTestRun, []*SingleTestRun) {
+	parallel := []*SingleTestRun{}
+	serial := []*SingleTestRun{}
 
-			atomic.Executor.Command = interpolateWithArgs(atomic.Executor.Command, atomic, args)
-			atomic.Executor.CleanupCommand = interpolateWithArgs(atomic.Executor.CleanupCommand, atomic, args)
-			for i,_ := range atomic.Dependencies {
-				dep := &atomic.Dependencies[i]
-				dep.PrereqCommand = interpolateWithArgs(dep.PrereqCommand, atomic, args)
-				dep.GetPrereqCommand = interpolateWithArgs(dep.GetPrereqCommand, atomic, args)
+	resourceCounts := map[string]int{}
+	for _, t := range tests {
+		seen := map[string]bool{}
+		for _, resource := range GetCriteriaResources(t.criteria) {
+			if !seen[resource] {
+				seen[resource] = true
+				resourceCounts[resource] += 1
 			}
+		}
+	}
 
-			runConfig := BuildRunSpec(atomic, testIndex, testRun.criteria, workingDir, resultsDir)
-			if runConfig == "" {
-				fmt.Println("empty runconfig!, skipping", rec)
-				continue
+	for _, t := range tests {
+		isShared := false
+		for _, resource := range GetCriteriaResources(t.criteria) {
+			if resourceCounts[resource] > 1 {
+				isShared = true
+				break
 			}
+		}
+		if t.isElevationRequired || isShared {
+			serial = append(serial, t)
+		} else {
+			parallel = append(parallel, t)
+		}
+	}
+	return parallel, serial
+}
 
-			if runtime.GOOS == "windows" {
-				os.Chmod(workingDir, 0600)
-				os.Chmod(resultsDir, 0600)
-			} else {
-				os.Chmod(workingDir, 0777) // runner cleans up workingDir
-				os.Chmod(resultsDir, 0777)
+/*
+ * Runs tests with flagParallel workers.  Returns number of tests run.
+ */
+func RunParallelTests(tests []*SingleTestRun, testRuns []*SingleTestRun) int {
+	numTestsRun := 0
+	queue := make(chan *SingleTestRun)
+	var wg sync.WaitGroup
+
+	for i := 0; i < flagParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for testRun := range queue {
+				ExecuteTestRun(testRun, testRuns)
+				gStateLock.Lock()
+				numTestsRun += 1
+				gStateLock.Unlock()
 			}
+		}()
+	}
 
-			if !gFlagNoRun {
-				testRun.state = types.StateRunnerLaunched
-				SaveState(testRuns)
+	for _, testRun := range tests {
+		if false == gKeepRunning {
+			break
+		}
+		queue <- testRun
+	}
+	close(queue)
+	wg.Wait()
 
-				if runtime.GOOS == "windows" {
-					GoArtRunTestWin(testRun, runConfig)
-				} else {
-					GoArtRunTest(testRun, runConfig)
-				}
-				testRun.state = types.StateRunnerFinished
+	return numTestsRun
+}
 
-				UpdateTimestampsFromRunSummary(testRun)
+func RunTests() {
+	numTestsRun := 0
+	testRuns := []*SingleTestRun{}
+	readyRuns := []*SingleTestRun{}
 
-				SaveState(testRuns)
-			}
-			numTestsRun += 1
+	startTime := time.Now().Unix()
 
-			// fix permissions after run
-			if runtime.GOOS != "windows" {
-				os.Chmod(resultsDir, 0755)
+	if "test" == flagFetchMode && !gFlagNoRun {
+		maxTests := 0
+		for _, spec := range gTestSpecs {
+			maxTests += len(spec.Criteria)
+		}
+		gTestFetcher = NewTestFetcher(maxTests, func() []*SingleTestRun {
+			gStateLock.Lock()
+			defer gStateLock.Unlock()
+			return testRuns
+		})
+	}
+
+	for _, spec := range gTestSpecs {
+
+		for _, rec := range spec.Criteria {
+
+			testRun := &SingleTestRun{}
+			testRun.criteria = rec
+			testRun.state = types.StateCriteriaLoaded
+			gStateLock.Lock()
+			testRuns = append(testRuns, testRun)
+			gStateLock.Unlock()
+
+			SaveState(testRuns)
+
+			if false == PrepareTestRun(testRun, spec, testRuns) {
+				continue
 			}
 
-			// runner will try to clean up, but may not be able to with lowered privs
-			err = os.RemoveAll(workingDir)
-			if err != nil {
-				fmt.Println("Failed to delete working dir", workingDir, err)
+			if flagParallel > 1 {
+				// run after all tests are prepared
+				readyRuns = append(readyRuns, testRun)
+				continue
 			}
 
+			ExecuteTestRun(testRun, testRuns)
+			numTestsRun += 1
+
 			if false == gKeepRunning {
 				break
 			}
@@ -1316,6 +1673,31 @@ func RunTests() {
 			break
 		}
 	}
+
+	if len(readyRuns) > 0 {
+		parallel, serial := PartitionParallelTests(readyRuns)
+		fmt.Printf("Running %d tests with %d workers, %d tests serially\n", len(parallel), flagParallel, len(serial))
+
+		numTestsRun += RunParallelTests(parallel, testRuns)
+
+		for _, testRun := range serial {
+			if false == gKeepRunning {
+				break
+			}
+			if !gFlagNoRun {
+				time.Sleep(3 * time.Second)
+			}
+			ExecuteTestRun(testRun, testRuns)
+			numTestsRun += 1
+		}
+
+		// remove working dirs of tests that did not run due to interrupt
+		for _, testRun := range readyRuns {
+			if testRun.state == types.StateCriteriaLoaded {
+				os.RemoveAll(testRun.workingDir)
+			}
+		}
+	}
 	endTime := time.Now().Unix()
 
 	// fix ownership of results dirs
@@ -1336,30 +1718,47 @@ func RunTests() {
 		os.Chmod(flagResultsPath, 0755)
 	}
 
-	// now get telemetry
-	if false == gFlagNoRun && true == gKeepRunning {
+	// with --fetchmode test, wait for telemetry of tests that ran
+
+	if gTestFetcher != nil {
+		gTestFetcher.Close()
+		gTestFetcher = nil
+		for _, testRun := range testRuns {
+			WriteTestRunStatusFile(testRun)
+		}
+		SaveState(testRuns)
+	} else if false == gFlagNoRun && true == gKeepRunning {
+		// now get telemetry
 		if 0 == numTestsRun {
 			fmt.Println("no tests were run, exiting without looking for telemetry")
 		} else {
 			FetchTelemetry(flagResultsPath, startTime, endTime)
 
+			toValidate := []*SingleTestRun{}
 			for _, testRun := range testRuns {
 				if testRun.status == types.StatusTestSuccess {
 					testRun.state = types.StateWaitForTelemetry
-					SaveState(testRuns)
+					toValidate = append(toValidate, testRun)
+				}
+			}
+			SaveState(testRuns)
 
-					for _, tool := range gTelemTools {
-						ValidateSimpleTelemetry(testRun, tool)
-					}
+			ValidateTestRuns(toValidate, gTelemTools, flagResultsPath)
 
+			for _, testRun := range testRuns {
+				if testRun.state == types.StateWaitForTelemetry {
 					testRun.state = types.StateDone
 				}
 				WriteTestRunStatusFile(testRun)
-				SaveState(testRuns)
 			}
+			SaveState(testRuns)
 		}
 	}
 
+	WriteMetricsFiles(testRuns)
+	WriteAttackLayer(testRuns)
+	WriteReports(testRuns)
+
 	fmt.Println("Done. Output in", flagResultsPath)
 	fmt.Println(SPrintState(testRuns, true))
 }
@@ -1404,8 +1803,8 @@ func LoadSpecsForRevalidate(prevResultsDir string, dest *[]*types.TestSpec) {
 	}
 
 	for _, entry := range results {
-		if int(entry.Status) >= int(types.StatusTestSuccess) {
-			continue
+		if int(entry.Status) < int(types.StatusTestSuccess) {
+			continue // did not run, nothing to validate
 		}
 		spec := &types.TestSpec{}
 
@@ -1420,38 +1819,32 @@ func LoadSpecsForRevalidate(prevResultsDir string, dest *[]*types.TestSpec) {
 func Revalidate(prevResultsDir string) {
 	flagResultsPath = prevResultsDir
 	testRuns := []*SingleTestRun{}
+	toValidate := []*SingleTestRun{}
 
 	for _, spec := range gTestSpecs {
 
 		for _, rec := range spec.Criteria {
 
-			resultsDir := filepath.FromSlash(flagResultsPath + "/" + rec.Technique + "_" + fmt.Sprintf("%d", rec.TestIndex) + "_" + rec.TestGuid)
+			// same as RunTests(), older versions appended TestGuid
+
+			resultsDir := filepath.FromSlash(flagResultsPath + "/" + rec.Technique + "_" + fmt.Sprintf("%d", rec.TestIndex))
+			if _, err := os.Stat(resultsDir); err != nil {
+				resultsDir += "_" + rec.TestGuid
+			}
 			testRun := &SingleTestRun{}
 			testRun.criteria = rec
 			testRun.resultsDir = resultsDir
 			testRun.state = types.StateCriteriaLoaded
 			testRuns = append(testRuns, testRun)
 
-			// TODO: load runspec, extract workingDir
 			runConfig := &types.RunSpec{}
-			//runConfig := BuildRunSpec(rec, workingDir, resultsDir)
-			//testRun.workingDir = workingDir
-
-			path := filepath.FromSlash(resultsDir + "/runspec.json")
-			body, err := os.ReadFile(path)
+			err := utils.LoadRunSpec(resultsDir, runConfig)
 			if err != nil {
-				fmt.Println("Failed to load", path, err)
-				continue
-			}
-			if len(body) == 0 {
-				fmt.Println("runspec.json is empty")
-				continue
-			}
-			if err = json.Unmarshal(body, runConfig); err != nil {
-				fmt.Println("failed to parse", path, err)
+				fmt.Println("Failed to load runspec", err)
 				continue
 			}
 			testRun.workingDir = runConfig.TempDir
+			UpdateTimestampsFromRunSummary(testRun)
 
 			// load atomic to get default args
 			atomic,_ := LoadAtomic(rec.Technique, rec.TestIndex, rec.TestGuid, filepath.FromSlash(flagAtomicsPath), gVerbose)
@@ -1471,19 +1864,31 @@ func Revalidate(prevResultsDir string) {
 				SaveState(testRuns)
 				continue
 			}
+			toValidate = append(toValidate, testRun)
 		}
 	}
 
-	for _, testRun := range testRuns {
+	// telemetry fetched with --fetchmode test is in results dir of each test
 
-		for _, tool := range gTelemTools {
-			ValidateSimpleTelemetry(testRun, tool)
+	runTelemetry := []*SingleTestRun{}
+	for _, testRun := range toValidate {
+		if HasTestTelemetry(testRun) {
+			ValidateTestRuns([]*SingleTestRun{testRun}, gTelemTools, testRun.resultsDir)
+		} else {
+			runTelemetry = append(runTelemetry, testRun)
 		}
+	}
+	ValidateTestRuns(runTelemetry, gTelemTools, flagResultsPath)
 
+	for _, testRun := range toValidate {
 		testRun.state = types.StateDone
 		WriteTestRunStatusFile(testRun)
-		SaveState(testRuns)
 	}
+	SaveState(testRuns)
+
+	WriteMetricsFiles(testRuns)
+	WriteAttackLayer(testRuns)
+	WriteReports(testRuns)
 
 	fmt.Println("Done. Output in", flagResultsPath)
 	fmt.Println(SPrintState(testRuns, true))
@@ -1528,11 +1933,55 @@ func PrepTelemTools(arg string) []*TelemTool {
 	return ret
 }
 
+func IsFlagPassed(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
 func main() {
 
 	flag.Parse()
 	flagTechniques := flag.Args()
 
+	switch flagAttribution {
+	case "time", "tree", "both":
+	default:
+		fmt.Println("ERROR: --attribution should be time, tree or both:", flagAttribution)
+		os.Exit(1)
+	}
+	switch flagCombine {
+	case "any", "all", "columns":
+	default:
+		fmt.Println("ERROR: --combine should be any, all or columns:", flagCombine)
+		os.Exit(1)
+	}
+	if flagTelemetryTimeout > 0 && !IsFlagPassed("fetchmode") {
+		flagFetchMode = "test"
+	}
+	switch flagFetchMode {
+	case "run", "test":
+	default:
+		fmt.Println("ERROR: --fetchmode should be run or test:", flagFetchMode)
+		os.Exit(1)
+	}
+	if flagReport != "" {
+		for _, format := range strings.Split(flagReport, ",") {
+			if !IsReportFormat(format) {
+				fmt.Println("ERROR: --report should be one or more of "+strings.Join(kReportFormats, ",")+":", format)
+				os.Exit(1)
+			}
+		}
+	}
+	if flagParallel > 1 && !IsFlagPassed("attribution") {
+		// time windows of concurrent tests overlap
+		flagAttribution = "both"
+	}
+
 	FillInToolPathDefaults()
 
 	err := GetSysInfo(gSysInfo)
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -16,3 +25,377 @@ func TestTelemTools(t *testing.T) {
 	assert.Equal(t, "telemtool_e2e.exe", tools[1].Name)
 	assert.Equal(t, "_e2e", tools[1].Suffix)
 }
+
+func newFileCriteria(technique string, paths ...string) *types.AtomicTestCriteria {
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = technique
+	for i, path := range paths {
+		exp := &types.ExpectedEvent{Id: fmt.Sprintf("%d", i), EventType: "File", SubType: "WRITE"}
+		exp.FieldChecks = []types.FieldCriteria{{FieldName: "path", Op: "=", Value: path}}
+		criteria.ExpectedEvents = append(criteria.ExpectedEvents, exp)
+	}
+	return criteria
+}
+
+func TestPartitionParallelTests(t *testing.T) {
+	tests := []*SingleTestRun{
+		{criteria: newFileCriteria("T1000", "/tmp/a")},
+		{criteria: newFileCriteria("T1001", "/tmp/b", "/tmp/shared")},
+		{criteria: newFileCriteria("T1002", "/tmp/shared")},
+		{criteria: newFileCriteria("T1003"), isElevationRequired: true},
+		{criteria: newFileCriteria("T1004", "/tmp/c", "/tmp/c")},
+	}
+
+	parallel, serial := PartitionParallelTests(tests)
+	assert.Equal(t, 2, len(parallel))
+	assert.Equal(t, "T1000", parallel[0].criteria.Technique)
+	assert.Equal(t, "T1004", parallel[1].criteria.Technique)
+	assert.Equal(t, 3, len(serial))
+	assert.Equal(t, "T1001", serial[0].criteria.Technique)
+	assert.Equal(t, "T1002", serial[1].criteria.Technique)
+	assert.Equal(t, "T1003", serial[2].criteria.Technique)
+
+	// dest paths, process exes and netflow ports are also shared
+	newCriteria := func(technique, eventType, fieldName, value string) *types.AtomicTestCriteria {
+		criteria := &types.AtomicTestCriteria{}
+		criteria.Technique = technique
+		exp := &types.ExpectedEvent{Id: "0", EventType: eventType}
+		exp.FieldChecks = []types.FieldCriteria{{FieldName: fieldName, Op: "=", Value: value}}
+		criteria.ExpectedEvents = append(criteria.ExpectedEvents, exp)
+		return criteria
+	}
+	tests = []*SingleTestRun{
+		{criteria: newFileCriteria("T1000", "/tmp/a")},
+		{criteria: newCriteria("T1001", "File", "dest_path", "/tmp/a")},
+		{criteria: newCriteria("T1002", "Process", "exepath", "/usr/bin/crontab")},
+		{criteria: newCriteria("T1003", "Process", "exepath", "/usr/bin/crontab")},
+		{criteria: newCriteria("T1004", "Netflow", "dst_port", "4444")},
+		{criteria: newCriteria("T1005", "Netflow", "src_port", "4444")},
+		{criteria: newCriteria("T1006", "Process", "cmdline", "/tmp/a")},
+	}
+	parallel, serial = PartitionParallelTests(tests)
+	assert.Equal(t, 1, len(parallel))
+	assert.Equal(t, "T1006", parallel[0].criteria.Technique)
+	assert.Equal(t, 6, len(serial))
+}
+
+func TestLoadFileCorrelations(t *testing.T) {
+	prev := gRecs
+	gRecs = []*types.AtomicTestCriteria{}
+	defer func() { gRecs = prev }()
+
+	path := filepath.Join(t.TempDir(), "criteria.csv")
+	data := `T1000,linux,1,valid
+_C_,Sequence,Before,0,1,maxgap=5s
+_E_,Process,cmdline~=whoami
+_E_,Process,cmdline~=id
+T1000,linux,2,one index
+_E_,Process,cmdline~=whoami
+_C_,Sequence,Before,0,maxgap=5s
+T1000,linux,3,unknown index
+_E_,Process,cmdline~=whoami
+_C_,Process,Child,0,1
+T1000,linux,4,unsupported
+_E_,Process,cmdline~=whoami
+_E_,Process,cmdline~=id
+_C_,Process,Sibling,0,1
+T1000,linux,5,short row
+_C_,Process
+`
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0644))
+	assert.Nil(t, LoadFile(path, &map[string][]*types.TestSpec{}))
+
+	assert.Equal(t, 5, len(gRecs))
+	assert.Equal(t, 0, len(gRecs[0].Warnings))
+	assert.Equal(t, int64(5*time.Second), gRecs[0].ExpectedCorrelations[0].MaxGapNs)
+	for _, rec := range gRecs[1:] {
+		assert.Equal(t, 1, len(rec.Warnings), rec.TestName)
+	}
+}
+
+func TestToolStatusColumns(t *testing.T) {
+	prevTools, prevCombine := gTelemTools, flagCombine
+	defer func() { gTelemTools, flagCombine = prevTools, prevCombine }()
+
+	gTelemTools = PrepTelemTools("telemtool_a,telemtool_b")
+	flagCombine = "columns"
+
+	criteria := newFileCriteria("T1000", "/tmp/a")
+	testRun := &SingleTestRun{criteria: criteria, resultsDir: t.TempDir()}
+	for _, tool := range gTelemTools {
+		testRun.validators = append(testRun.validators, NewValidator(testRun, tool))
+	}
+	testRun.validators[0].State.TestData.ExpectedEvents[0].Matches = []*types.SimpleEvent{{}}
+	testRun.validators[0].Status = types.StatusValidateSuccess
+	testRun.validators[1].Status = types.StatusValidateFail
+	testRun.status = types.StatusValidateFail
+
+	assert.Equal(t, "Validated    F                NoTelemetry  <F>              ", SPrintToolColumns(testRun))
+
+	WriteTestRunStatusFile(testRun)
+	data, err := os.ReadFile(filepath.Join(testRun.resultsDir, "status.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "11\nNoTelemetry\n_a 13 Validated F\n_b 11 NoTelemetry <F>", string(data))
+
+	s := SPrintState([]*SingleTestRun{testRun}, false)
+	assert.Contains(t, s, "=== Tools:_a,_b Combine:columns\n")
+}
+
+func TestTelemetryPolling(t *testing.T) {
+	prevTimeout, prevGrace := flagTelemetryTimeout, flagTelemetryGrace
+	defer func() { flagTelemetryTimeout, flagTelemetryGrace = prevTimeout, prevGrace }()
+
+	endTime := time.Now().Add(-time.Minute).Round(0)
+	testRun := &SingleTestRun{criteria: newFileCriteria("T1000", "/tmp/a"), resultsDir: t.TempDir()}
+	testRun.EndTime = endTime.UnixNano()
+
+	flagTelemetryTimeout, flagTelemetryGrace = 0, 5
+	f := &TestFetcher{}
+	p := f.NewTestPoll(testRun)
+	assert.Equal(t, endTime.Add(5*time.Second), p.next)
+	assert.Equal(t, p.next, p.deadline)
+
+	flagTelemetryTimeout = 60
+	f.deadline = endTime.Add(20 * time.Second)
+	p = f.NewTestPoll(testRun)
+	assert.Equal(t, endTime.Add(kPollInitialInterval), p.next)
+	assert.Equal(t, f.deadline, p.deadline)
+
+	v := NewValidator(testRun, &TelemTool{})
+	testRun.validators = []*Validator{v}
+	exp := v.State.TestData.ExpectedEvents[0]
+	exp.Matches = []*types.SimpleEvent{{Timestamp: testRun.EndTime - int64(time.Second)}}
+
+	p.numPolls = 1
+	assert.False(t, RecordLatency(p, testRun.EndTime+int64(2*time.Second)))
+	assert.Equal(t, int64(3000), exp.TimeToFirstMatchMs)
+	assert.Equal(t, int64(0), v.State.TimeToFullCoverageMs)
+
+	v.State.Coverage = 1.0
+	p.numPolls = 2
+	assert.True(t, RecordLatency(p, testRun.EndTime+int64(6*time.Second)))
+	assert.Equal(t, int64(3000), exp.TimeToFirstMatchMs)
+	assert.Equal(t, int64(6000), v.State.TimeToFullCoverageMs)
+	assert.Equal(t, 2, v.State.NumPolls)
+
+	data, err := os.ReadFile(filepath.Join(testRun.resultsDir, "validate_summary.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "\"time_to_full_coverage_ms\": 6000")
+
+	// second tool not covered yet, done only with --combine any
+	prevCombine := flagCombine
+	defer func() { flagCombine = prevCombine }()
+	v2 := NewValidator(testRun, &TelemTool{Name: "telemtool_b", Suffix: "_b"})
+	testRun.validators = append(testRun.validators, v2)
+	flagCombine = "all"
+	assert.False(t, RecordLatency(p, testRun.EndTime+int64(8*time.Second)))
+	flagCombine = "any"
+	assert.True(t, RecordLatency(p, testRun.EndTime+int64(8*time.Second)))
+}
+
+func TestTestFetcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("telemetry tool is a shell script")
+	}
+	prevTools, prevResults, prevGrace, prevTimeout, prevFilter := gTelemTools, flagResultsPath, flagTelemetryGrace, flagTelemetryTimeout, flagFilterByGoartrunShell
+	defer func() {
+		gTelemTools, flagResultsPath, flagTelemetryGrace, flagTelemetryTimeout, flagFilterByGoartrunShell = prevTools, prevResults, prevGrace, prevTimeout, prevFilter
+	}()
+	flagResultsPath = t.TempDir()
+	flagTelemetryGrace, flagTelemetryTimeout = 0, 0
+	flagFilterByGoartrunShell = false
+
+	endTime := time.Now().Add(-time.Minute)
+	evt := &types.SimpleEvent{EventType: types.SimpleSchemaProcess, Timestamp: endTime.UnixNano() - int64(time.Second)}
+	evt.ProcessFields = &types.SimpleProcessFields{Pid: 100, Cmdline: "whoami"}
+	j, err := json.Marshal(evt)
+	assert.Nil(t, err)
+
+	// writes the event to --resultsdir of the test
+	toolDir := t.TempDir()
+	fixture := filepath.Join(toolDir, "event.json")
+	assert.Nil(t, os.WriteFile(fixture, append(j, '\n'), 0644))
+	tool := filepath.Join(toolDir, "telemtool")
+	script := "#!/bin/sh\nwhile [ $# -gt 0 ]; do [ \"$1\" = --resultsdir ] && cp " + fixture + " \"$2/simple_telemetry.json\"; shift; done\n"
+	assert.Nil(t, os.WriteFile(tool, []byte(script), 0755))
+	gTelemTools = PrepTelemTools(tool)
+
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = "T1000"
+	criteria.TestIndex = 1
+	criteria.ExpectedEvents = []*types.ExpectedEvent{{Id: "0", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "~=", Value: "whoami"}}}}
+	testRun := &SingleTestRun{criteria: criteria, resultsDir: filepath.Join(flagResultsPath, "T1000_1")}
+	testRun.StartTime = endTime.UnixNano() - int64(2*time.Second)
+	testRun.EndTime = endTime.UnixNano()
+	assert.Nil(t, os.Mkdir(testRun.resultsDir, 0755))
+
+	f := NewTestFetcher(1, func() []*SingleTestRun { return []*SingleTestRun{testRun} })
+	f.Add(testRun)
+	f.Close()
+
+	assert.Equal(t, types.StateDone, testRun.state)
+	assert.Equal(t, types.StatusValidateSuccess, testRun.status)
+	assert.True(t, HasTestTelemetry(testRun))
+	data, err := os.ReadFile(filepath.Join(testRun.resultsDir, "status.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\nValidated", types.StatusValidateSuccess), string(data))
+	_, err = os.Stat(filepath.Join(flagResultsPath, "simple_telemetry.json"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(flagResultsPath, "status.json"))
+	assert.Nil(t, err)
+
+	// no time range of test
+	testRun = &SingleTestRun{criteria: criteria, resultsDir: t.TempDir()}
+	assert.False(t, FetchAndValidateTestRun(testRun))
+	assert.Equal(t, types.StateDone, testRun.state)
+}
+
+func TestCollectMetrics(t *testing.T) {
+	assert.Equal(t, int64(3), Percentile([]int64{1, 2, 3, 4}, 75))
+	assert.Equal(t, int64(4), Percentile([]int64{1, 2, 3, 4}, 99))
+	assert.Equal(t, int64(1), Percentile([]int64{1, 2, 3, 4}, 1))
+
+	ms := int64(time.Millisecond)
+	tool := &TelemTool{}
+	testRun := &SingleTestRun{criteria: newFileCriteria("T1000", "/tmp/a")}
+	testRun.StartTime = 1000 * ms
+	v := NewValidator(testRun, tool)
+	testRun.validators = []*Validator{v}
+
+	proc := &types.SimpleEvent{EventType: types.SimpleSchemaProcess, Timestamp: 1100 * ms, IngestTimestamp: 1400 * ms}
+	file := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: 1300 * ms}
+	v.State.TestData.ExpectedEvents[0].Matches = []*types.SimpleEvent{proc, file, proc}
+	negated := &types.ExpectedEvent{Id: "1", EventType: "Netflow", IsNegated: true}
+	negated.Matches = []*types.SimpleEvent{{EventType: types.SimpleSchemaNetflow, Timestamp: 1200 * ms}}
+	v.State.TestData.ExpectedEvents = append(v.State.TestData.ExpectedEvents, negated)
+
+	metrics := CollectMetrics([]*SingleTestRun{testRun}, tool)
+	assert.Equal(t, 1, metrics.NumTests)
+	assert.Equal(t, 1, metrics.EventTypes["P"].NumEvents)
+	assert.Equal(t, int64(100), metrics.EventTypes["P"].SinceStart.P50Ms)
+	assert.Equal(t, int64(300), metrics.EventTypes["P"].IngestDelay.MaxMs)
+	assert.Equal(t, int64(300), metrics.EventTypes["F"].SinceStart.MinMs)
+	assert.Nil(t, metrics.EventTypes["F"].IngestDelay)
+	assert.Nil(t, metrics.EventTypes["N"])
+
+	prevTools, prevResults := gTelemTools, flagResultsPath
+	defer func() { gTelemTools, flagResultsPath = prevTools, prevResults }()
+	gTelemTools = []*TelemTool{tool, {Name: "telemtool_b", Suffix: "_b"}}
+	flagResultsPath = t.TempDir()
+	WriteMetricsFiles([]*SingleTestRun{testRun})
+	metrics = RunMetrics{}
+	data, err := os.ReadFile(filepath.Join(flagResultsPath, "metrics.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &metrics))
+	assert.Equal(t, 1, metrics.EventTypes["P"].NumTests)
+	assert.Equal(t, int64(100), metrics.EventTypes["P"].SinceStart.P50Ms)
+	_, err = os.Stat(filepath.Join(flagResultsPath, "metrics_b.json"))
+	assert.Nil(t, err)
+}
+
+func TestJUnitReport(t *testing.T) {
+	newTestRun := func(technique string, status types.TestStatus, paths ...string) *SingleTestRun {
+		testRun := &SingleTestRun{criteria: newFileCriteria(technique, paths...), status: status, resultsDir: t.TempDir()}
+		testRun.validators = []*Validator{NewValidator(testRun, &TelemTool{})}
+		return testRun
+	}
+	validated := newTestRun("T1000", types.StatusValidateSuccess, "/tmp/a")
+	validated.validators[0].State.TestData.ExpectedEvents[0].Matches = []*types.SimpleEvent{{}}
+	partial := newTestRun("T1000", types.StatusValidatePartial, "/tmp/a", "/tmp/b")
+	partial.validators[0].State.TestData.ExpectedEvents[0].Matches = []*types.SimpleEvent{{}}
+	assert.Nil(t, os.WriteFile(filepath.Join(partial.resultsDir, "runner-stdout.txt"), []byte("hello <world>"), 0644))
+	skipped := newTestRun("T1001", types.StatusSkipped)
+	runnerFail := newTestRun("T1001", types.StatusRunnerFailure)
+
+	report := NewJUnitReport([]*SingleTestRun{validated, partial, skipped, runnerFail})
+	assert.Equal(t, 2, len(report.Suites))
+	assert.Equal(t, []int{4, 1, 1, 1}, []int{report.Tests, report.Failures, report.Errors, report.Skipped})
+
+	c := report.Suites[0].Cases[1]
+	assert.Equal(t, "Partial: missing 1 File WRITE path=/tmp/b", c.Failure.Message)
+	assert.Equal(t, "hello <world>", c.SystemOut)
+	assert.Nil(t, report.Suites[0].Cases[0].Failure)
+	assert.NotNil(t, report.Suites[1].Cases[0].Skipped)
+	assert.Equal(t, "RunnerFail", report.Suites[1].Cases[1].Error.Message)
+
+	prevReport, prevResults := flagReport, flagResultsPath
+	defer func() { flagReport, flagResultsPath = prevReport, prevResults }()
+	flagReport = "junit"
+	flagResultsPath = t.TempDir()
+	WriteReports([]*SingleTestRun{validated, partial, skipped, runnerFail})
+	data, err := os.ReadFile(filepath.Join(flagResultsPath, "junit.xml"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `<testsuite name="T1000" tests="2" failures="1" errors="0" skipped="0"`)
+	assert.Contains(t, string(data), `<failure message="Partial: missing 1 File WRITE path=/tmp/b"`)
+	assert.Contains(t, string(data), "<system-out>hello &lt;world&gt;</system-out>")
+	_, err = os.Stat(filepath.Join(flagResultsPath, "index.html"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHtmlReport(t *testing.T) {
+	prev := flagFilterFileEventsTmp
+	flagFilterFileEventsTmp = false
+	defer func() { flagFilterFileEventsTmp = prev }()
+
+	criteria := newFileCriteria("T1000", "/tmp/a", "/tmp/b")
+	criteria.TestIndex = 2
+	criteria.TestName = "write <files>"
+	criteria.ExpectedEvents[0].FieldChecks = append(criteria.ExpectedEvents[0].FieldChecks, types.FieldCriteria{FieldName: "pid", Op: "=", Value: "42"})
+	testRun := &SingleTestRun{criteria: criteria, status: types.StatusValidatePartial, resultsDir: t.TempDir()}
+	v := NewValidator(testRun, &TelemTool{})
+	testRun.validators = []*Validator{v}
+	assert.Nil(t, os.WriteFile(filepath.Join(testRun.resultsDir, "runner-stdout.txt"), []byte("<script>alert(1)</script>"), 0644))
+
+	write := func(path string, pid int64) *types.SimpleEvent {
+		return &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionOpenWrite, TargetPath: path, Pid: pid}}
+	}
+	CheckFileEvent(v, write("/tmp/b", 1), "")
+	CheckFileEvent(v, write("/tmp/a", 7), "")
+
+	report := NewHtmlReport([]*SingleTestRun{testRun})
+	assert.Equal(t, []string{"Partial:1"}, report.StatusCounts)
+	assert.Equal(t, "T1000_2", report.Tests[0].Anchor)
+	events := report.Tests[0].Tools[0].Events
+	assert.Equal(t, "missing", events[0].Result)
+	assert.Equal(t, 1, len(events[0].NearMisses))
+	assert.Equal(t, "1 of 2 field checks satisfied; pid=42, actual '7'", events[0].NearMisses[0].Summary)
+	assert.Equal(t, "seen", events[1].Result)
+	assert.Equal(t, 1, len(events[1].Matches))
+
+	prevReport, prevResults := flagReport, flagResultsPath
+	defer func() { flagReport, flagResultsPath = prevReport, prevResults }()
+	flagReport = "html"
+	flagResultsPath = t.TempDir()
+	WriteReports([]*SingleTestRun{testRun})
+	data, err := os.ReadFile(filepath.Join(flagResultsPath, "index.html"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `<a href="#T1000_2">`)
+	assert.Contains(t, string(data), `<div class="test" id="T1000_2">`)
+	assert.Contains(t, string(data), `path=/tmp/b`)
+	assert.Contains(t, string(data), `write &lt;files&gt;`)
+	assert.NotContains(t, string(data), "<script")
+	_, err = os.Stat(filepath.Join(flagResultsPath, "junit.xml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteAttackLayer(t *testing.T) {
+	prevResults, prevNames := flagResultsPath, gMitreTechniqueNames
+	defer func() { flagResultsPath, gMitreTechniqueNames = prevResults, prevNames }()
+	flagResultsPath = t.TempDir()
+	gMitreTechniqueNames = map[string]string{"T1000": "Some Technique"}
+
+	validated := &SingleTestRun{criteria: newFileCriteria("T1000", "/tmp/a"), status: types.StatusValidateSuccess, matchString: "F"}
+	partial := &SingleTestRun{criteria: newFileCriteria("T1000", "/tmp/a", "/tmp/b"), status: types.StatusValidatePartial, matchString: "F<F>"}
+	validated.criteria.TestIndex = 1
+	partial.criteria.TestIndex = 2
+	WriteAttackLayer([]*SingleTestRun{validated, partial})
+
+	layer := utils.NavigatorLayer{}
+	data, err := os.ReadFile(filepath.Join(flagResultsPath, "attack_layer.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &layer))
+	assert.Equal(t, 1, len(layer.Techniques))
+	assert.Equal(t, 75.0, *layer.Techniques[0].Score)
+	assert.Equal(t, utils.NavigatorMetadata{Name: "name", Value: "Some Technique"}, layer.Techniques[0].Metadata[0])
+	assert.Contains(t, layer.Techniques[0].Comment, "#2 Partial F<F>")
+}
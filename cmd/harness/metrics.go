@@ -0,0 +1,187 @@
+package main
+
+/*
+ * Telemetry timing metrics of a run, per event type.  For every event
+ * matched by a validator, the time from StartTime of the test to the
+ * event timestamp, and the ingest delay when the telemetry tool
+ * provides ingest_ts, are aggregated into metrics{suffix}.json.
+ * Matches of negated (_N_) expected events are not counted.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+type LatencyStats struct {
+	Count  int     `json:"count"`
+	MinMs  int64   `json:"min_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  int64   `json:"p50_ms"`
+	P90Ms  int64   `json:"p90_ms"`
+	P95Ms  int64   `json:"p95_ms"`
+	P99Ms  int64   `json:"p99_ms"`
+	MaxMs  int64   `json:"max_ms"`
+}
+
+type EventTypeMetrics struct {
+	NumEvents   int           `json:"num_events"`
+	NumTests    int           `json:"num_tests"`              // tests with a matched event of this type
+	SinceStart  *LatencyStats `json:"since_start"`            // event ts - test StartTime
+	IngestDelay *LatencyStats `json:"ingest_delay,omitempty"` // ingest_ts - event ts
+}
+
+type RunMetrics struct {
+	NumTests   int                          `json:"num_tests"` // validated tests
+	EventTypes map[string]*EventTypeMetrics `json:"event_types"`
+}
+
+/*
+ * Percentile returns the nearest-rank percentile p of sorted values
+ */
+func Percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100.0*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+/*
+ * NewLatencyStats aggregates durations in ns.  Returns nil if empty.
+ */
+func NewLatencyStats(durations []int64) *LatencyStats {
+	if len(durations) == 0 {
+		return nil
+	}
+	ms := []int64{}
+	sum := int64(0)
+	for _, d := range durations {
+		ms = append(ms, d/int64(time.Millisecond))
+		sum += d
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i] < ms[j] })
+
+	return &LatencyStats{
+		Count:  len(ms),
+		MinMs:  ms[0],
+		MeanMs: float64(sum) / float64(len(ms)) / float64(time.Millisecond),
+		P50Ms:  Percentile(ms, 50),
+		P90Ms:  Percentile(ms, 90),
+		P95Ms:  Percentile(ms, 95),
+		P99Ms:  Percentile(ms, 99),
+		MaxMs:  ms[len(ms)-1],
+	}
+}
+
+/*
+ * MatchedEvents returns the distinct events matched by expected events
+ * and alerts of validator.  Matches of negated (_N_) events are
+ * failures rather than detections, and are left out.
+ */
+func MatchedEvents(v *Validator) []*types.SimpleEvent {
+	seen := map[*types.SimpleEvent]bool{}
+	events := []*types.SimpleEvent{}
+	add := func(matches []*types.SimpleEvent) {
+		for _, evt := range matches {
+			if evt == nil || seen[evt] {
+				continue
+			}
+			seen[evt] = true
+			events = append(events, evt)
+		}
+	}
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		if exp.IsNegated {
+			continue
+		}
+		add(exp.Matches)
+	}
+	for _, alert := range v.State.TestData.ExpectedAlerts {
+		add(alert.Matches)
+	}
+	return events
+}
+
+/*
+ * CollectMetrics aggregates timing of matched events of tests, for
+ * validators of tool.
+ */
+func CollectMetrics(tests []*SingleTestRun, tool *TelemTool) RunMetrics {
+	metrics := RunMetrics{EventTypes: map[string]*EventTypeMetrics{}}
+	sinceStart := map[string][]int64{}
+	ingestDelay := map[string][]int64{}
+
+	for _, testRun := range tests {
+		for _, v := range testRun.validators {
+			if v.tool.Key() != tool.Key() {
+				continue
+			}
+			metrics.NumTests += 1
+			typesInTest := map[string]bool{}
+			for _, evt := range MatchedEvents(v) {
+				if evt.Timestamp == 0 {
+					continue
+				}
+				key := string(evt.EventType)
+				m, ok := metrics.EventTypes[key]
+				if !ok {
+					m = &EventTypeMetrics{}
+					metrics.EventTypes[key] = m
+				}
+				m.NumEvents += 1
+				if !typesInTest[key] {
+					typesInTest[key] = true
+					m.NumTests += 1
+				}
+				if testRun.StartTime > 0 {
+					sinceStart[key] = append(sinceStart[key], evt.Timestamp-testRun.StartTime)
+				}
+				if evt.IngestTimestamp > 0 {
+					ingestDelay[key] = append(ingestDelay[key], evt.IngestTimestamp-evt.Timestamp)
+				}
+			}
+		}
+	}
+	for key, m := range metrics.EventTypes {
+		m.SinceStart = NewLatencyStats(sinceStart[key])
+		m.IngestDelay = NewLatencyStats(ingestDelay[key])
+	}
+	return metrics
+}
+
+/*
+ * WriteMetricsFiles writes metrics{suffix}.json for each telemetry tool
+ * into results dir of the run.
+ */
+func WriteMetricsFiles(tests []*SingleTestRun) {
+	gStateLock.Lock()
+	defer gStateLock.Unlock()
+
+	for _, tool := range gTelemTools {
+		metrics := CollectMetrics(tests, tool)
+		jb, err := json.MarshalIndent(&metrics, "", "  ")
+		if err != nil {
+			fmt.Println("ERROR: failed to encode metrics json", err)
+			continue
+		}
+		outPath := filepath.FromSlash(flagResultsPath + "/metrics" + tool.Suffix + ".json")
+		err = os.WriteFile(outPath, jb, 0644)
+		if err != nil {
+			fmt.Println("ERROR: unable to write file", outPath, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+/*
+ * Reports of a run for other tools, see --report
+ *
+ *  junit : junit.xml with a testsuite per technique and a testcase per test
+ *  html  : index.html, see report_html.go
+ *
+ * attack_layer.json, an ATT&CK Navigator layer of results, is always written.
+ */
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+)
+
+var kReportFormats = []string{"junit", "html"}
+
+type JUnitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Suites   []*JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitMessage `xml:"failure,omitempty"`
+	Error     *JUnitMessage `xml:"error,omitempty"`
+	Skipped   *JUnitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	duration time.Duration
+}
+
+type JUnitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+/*
+ * IsReportFormat returns true if format is a --report option
+ */
+func IsReportFormat(format string) bool {
+	for _, f := range kReportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * WriteReports writes each format of --report into results dir of run
+ */
+func WriteReports(tests []*SingleTestRun) {
+	if flagReport == "" {
+		return
+	}
+	gStateLock.Lock()
+	defer gStateLock.Unlock()
+
+	for _, format := range strings.Split(flagReport, ",") {
+		switch format {
+		case "junit":
+			WriteJUnitReport(tests, filepath.FromSlash(flagResultsPath+"/junit.xml"))
+		case "html":
+			WriteHtmlReport(tests, filepath.FromSlash(flagResultsPath+"/index.html"))
+		}
+	}
+}
+
+/*
+ * WriteAttackLayer writes ATT&CK Navigator layer of test results, see
+ * utils.NavigatorLayerFromResults()
+ */
+func WriteAttackLayer(tests []*SingleTestRun) {
+	gStateLock.Lock()
+	defer gStateLock.Unlock()
+
+	name := "atomic-harness " + filepath.Base(flagResultsPath)
+	layer := utils.NavigatorLayerFromResults(name, utils.GetPlatformName(), GetTestProgress(tests), gMitreTechniqueNames, gTechniquesMissingTests)
+	outPath := filepath.FromSlash(flagResultsPath + "/attack_layer.json")
+	err := utils.WriteNavigatorLayer(layer, outPath)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+	}
+}
+
+/*
+ * DescribeExpectedEvent returns a short description of expected event,
+ * like "1 File WRITE path=/tmp/a"
+ */
+func DescribeExpectedEvent(exp *types.ExpectedEvent) string {
+	s := exp.Id + " " + exp.EventType
+	if exp.SubType != "" {
+		s += " " + exp.SubType
+	}
+	for _, fc := range exp.FieldChecks {
+		s += " " + fc.FieldName + fc.Op + fc.Value
+	}
+	return s
+}
+
+/*
+ * UnmatchedCriteria returns descriptions of the required expected
+ * events and correlations of validator that were not seen, and negated
+ * events that were.
+ */
+func UnmatchedCriteria(v *Validator) []string {
+	a := []string{}
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		if exp.IsNegated {
+			if len(exp.Matches) > 0 {
+				a = append(a, "unexpected "+DescribeExpectedEvent(exp))
+			}
+			continue
+		}
+		if !exp.IsMaybe && len(exp.Matches) == 0 {
+			a = append(a, "missing "+DescribeExpectedEvent(exp))
+		}
+	}
+	for _, corr := range v.State.TestData.ExpectedCorrelations {
+		if !corr.IsMet {
+			a = append(a, "unmet correlation "+corr.Id+" "+corr.Type+" "+corr.SubType+" "+strings.Join(corr.EventIndexes, ","))
+		}
+	}
+	return a
+}
+
+/*
+ * NewJUnitTestCase maps status of test to pass, failure, error or skipped
+ */
+func NewJUnitTestCase(testRun *SingleTestRun) *JUnitTestCase {
+	c := &JUnitTestCase{ClassName: testRun.criteria.Technique}
+	c.Name = fmt.Sprintf("%s#%d %s", testRun.criteria.Technique, testRun.criteria.TestIndex, testRun.criteria.TestName)
+	if testRun.StartTime > 0 && testRun.EndTime > testRun.StartTime {
+		c.duration = time.Duration(testRun.EndTime - testRun.StartTime)
+	}
+	c.Time = fmt.Sprintf("%.3f", c.duration.Seconds())
+
+	msg := &JUnitMessage{Message: testRun.status.String(), Type: testRun.status.String()}
+
+	switch testRun.status {
+	case types.StatusValidateSuccess:
+		msg = nil
+	case types.StatusValidateFail, types.StatusValidatePartial, types.StatusValidateUnexpected:
+		lines := []string{}
+		for _, v := range testRun.validators {
+			prefix := ""
+			if len(testRun.validators) > 1 {
+				prefix = v.tool.Key() + " "
+			}
+			for _, s := range UnmatchedCriteria(v) {
+				lines = append(lines, prefix+s)
+			}
+		}
+		if len(lines) > 0 {
+			msg.Message += ": " + strings.Join(lines, "; ")
+		}
+		msg.Text = strings.Join(lines, "\n")
+		c.Failure = msg
+	case types.StatusSkipped, types.StatusAtomicNotFound, types.StatusCriteriaNotFound, types.StatusUnknown:
+		c.Skipped = msg
+	case types.StatusTestSuccess, types.StatusDelegateValidation:
+		msg.Message += ": not validated"
+		c.Skipped = msg
+	default:
+		c.Error = msg
+	}
+
+	data, err := os.ReadFile(filepath.FromSlash(testRun.resultsDir + "/runner-stdout.txt"))
+	if err == nil {
+		c.SystemOut = string(data)
+	}
+	return c
+}
+
+/*
+ * NewJUnitReport groups tests by technique, in order of first test
+ */
+func NewJUnitReport(tests []*SingleTestRun) *JUnitTestSuites {
+	report := &JUnitTestSuites{Name: "atomic-harness"}
+	suites := map[string]*JUnitTestSuite{}
+	suiteDurations := map[string]time.Duration{}
+
+	addCase := func(technique string, c *JUnitTestCase) {
+		suite, ok := suites[technique]
+		if !ok {
+			suite = &JUnitTestSuite{Name: technique}
+			suites[technique] = suite
+			report.Suites = append(report.Suites, suite)
+		}
+		suite.Cases = append(suite.Cases, c)
+		suite.Tests += 1
+		report.Tests += 1
+		if c.Failure != nil {
+			suite.Failures += 1
+			report.Failures += 1
+		} else if c.Error != nil {
+			suite.Errors += 1
+			report.Errors += 1
+		} else if c.Skipped != nil {
+			suite.Skipped += 1
+			report.Skipped += 1
+		}
+		suiteDurations[technique] += c.duration
+		suite.Time = fmt.Sprintf("%.3f", suiteDurations[technique].Seconds())
+	}
+
+	for _, testRun := range tests {
+		addCase(testRun.criteria.Technique, NewJUnitTestCase(testRun))
+	}
+	for _, tid := range gTechniquesMissingTests {
+		c := &JUnitTestCase{Name: tid, ClassName: tid, Time: "0.000"}
+		c.Skipped = &JUnitMessage{Message: "MissingTests", Type: "MissingTests"}
+		addCase(tid, c)
+	}
+	return report
+}
+
+/*
+ * WriteJUnitReport writes JUnit XML of tests to outPath
+ */
+func WriteJUnitReport(tests []*SingleTestRun, outPath string) {
+	data, err := xml.MarshalIndent(NewJUnitReport(tests), "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: failed to encode junit xml", err)
+		return
+	}
+	data = append([]byte(xml.Header), data...)
+	err = os.WriteFile(outPath, data, 0644)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+	}
+}
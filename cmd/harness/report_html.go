@@ -0,0 +1,272 @@
+package main
+
+/*
+ * index.html report, see --report html
+ *
+ * A single static file with the summary table, and a section per test
+ * with expected events, their field checks and matched events, the
+ * closest non-matching events of missing ones, and runner stdout.
+ * Test sections are shown one at a time using :target, so no scripts
+ * or network assets are needed.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+type HtmlReport struct {
+	Title        string
+	StatusCounts []string
+	Tests        []*HtmlTest
+	Missing      []string // techniques without tests
+}
+
+type HtmlTest struct {
+	Anchor      string
+	Technique   string
+	TestIndex   uint
+	Name        string
+	State       string
+	Status      string
+	StatusClass string
+	MatchString string
+	Detections  string
+	Tools       []*HtmlTool
+	Stdout      string
+}
+
+type HtmlTool struct {
+	Name         string
+	Status       string
+	Coverage     string
+	Events       []*HtmlExpectedEvent
+	Correlations []string
+	Alerts       []string
+}
+
+type HtmlExpectedEvent struct {
+	Id         string
+	Kind       string // required, optional or negated
+	EventType  string
+	SubType    string
+	Checks     []string
+	IsOk       bool
+	Result     string
+	Matches    []string // json of events
+	NearMisses []*HtmlNearMiss
+}
+
+type HtmlNearMiss struct {
+	Summary string
+	Event   string
+}
+
+var kHtmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 20px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 3px 6px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+pre { background: #f6f6f6; padding: 6px; white-space: pre-wrap; word-break: break-all; margin: 2px 0; }
+.test { display: none; }
+.test:target { display: block; }
+body:has(.test:target) #summary { display: none; }
+.ok { color: #080; }
+.fail { color: #c00; }
+.warn { color: #b60; }
+.skip { color: #777; }
+.mono { font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="summary">
+<h1>{{.Title}}</h1>
+<p>{{range .StatusCounts}}{{.}} &nbsp; {{end}}</p>
+<table>
+<tr><th>Technique</th><th>#</th><th>State</th><th>Status</th><th>Match</th><th>Detections</th><th>Name</th></tr>
+{{range .Tests}}<tr><td><a href="#{{.Anchor}}">{{.Technique}}</a></td><td>{{.TestIndex}}</td><td>{{.State}}</td><td class="{{.StatusClass}}">{{.Status}}</td><td class="mono">{{.MatchString}}</td><td>{{.Detections}}</td><td><a href="#{{.Anchor}}">{{.Name}}</a></td></tr>
+{{end}}{{range .Missing}}<tr><td>{{.}}</td><td>0</td><td>Skip</td><td class="skip">MissingTests</td><td></td><td></td><td></td></tr>
+{{end}}</table>
+</div>
+{{range .Tests}}
+<div class="test" id="{{.Anchor}}">
+<p><a href="#summary">&larr; summary</a></p>
+<h2>{{.Technique}} #{{.TestIndex}} {{.Name}}</h2>
+<p>Status: <span class="{{.StatusClass}}">{{.Status}}</span> <span class="mono">{{.MatchString}}</span> {{.Detections}}</p>
+{{range .Tools}}
+{{if .Name}}<h3>{{.Name}} <span class="mono">{{.Status}}</span></h3>{{end}}
+<p>Coverage: {{.Coverage}}</p>
+<table>
+<tr><th>Id</th><th>Kind</th><th>Type</th><th>Field Checks</th><th>Result</th><th>Events</th></tr>
+{{range .Events}}<tr>
+<td>{{.Id}}</td><td>{{.Kind}}</td><td>{{.EventType}} {{.SubType}}</td>
+<td class="mono">{{range .Checks}}{{.}}<br>{{end}}</td>
+<td class="{{if .IsOk}}ok{{else}}fail{{end}}">{{.Result}}</td>
+<td>{{if .Matches}}<details><summary>{{len .Matches}} matched</summary>{{range .Matches}}<pre>{{.}}</pre>{{end}}</details>{{end}}
+{{if .NearMisses}}<details><summary>{{len .NearMisses}} closest</summary>{{range .NearMisses}}<p>{{.Summary}}</p><pre>{{.Event}}</pre>{{end}}</details>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Correlations}}<h4>Correlations</h4><ul>{{range .Correlations}}<li class="mono">{{.}}</li>{{end}}</ul>{{end}}
+{{if .Alerts}}<h4>Detections</h4><ul>{{range .Alerts}}<li class="mono">{{.}}</li>{{end}}</ul>{{end}}
+{{end}}
+{{if .Stdout}}<h3>Runner stdout</h3>
+<pre>{{.Stdout}}</pre>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+/*
+ * StatusClass returns css class of status in html report
+ */
+func StatusClass(status types.TestStatus) string {
+	switch status {
+	case types.StatusValidateSuccess:
+		return "ok"
+	case types.StatusValidatePartial:
+		return "warn"
+	case types.StatusValidateFail, types.StatusValidateUnexpected:
+		return "fail"
+	case types.StatusSkipped, types.StatusAtomicNotFound, types.StatusCriteriaNotFound:
+		return "skip"
+	}
+	return "fail"
+}
+
+/*
+ * EventJson returns indented json of event
+ */
+func EventJson(evt *types.SimpleEvent) string {
+	jb, err := json.MarshalIndent(evt, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(jb)
+}
+
+/*
+ * NewHtmlTool describes the expected events of validator
+ */
+func NewHtmlTool(v *Validator, isNamed bool) *HtmlTool {
+	tool := &HtmlTool{Status: v.Status.String(), Coverage: fmt.Sprintf("%.2f", v.State.Coverage)}
+	if isNamed {
+		tool.Name = v.tool.Key()
+	}
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		e := &HtmlExpectedEvent{Id: exp.Id, Kind: "required", EventType: exp.EventType, SubType: exp.SubType}
+		for _, fc := range exp.FieldChecks {
+			e.Checks = append(e.Checks, fc.FieldName+fc.Op+fc.Value)
+		}
+		isSeen := len(exp.Matches) > 0
+		e.Result = "seen"
+		switch {
+		case exp.IsNegated:
+			e.Kind = "negated"
+			e.IsOk = !isSeen
+			if !isSeen {
+				e.Result = "not seen"
+			}
+		case exp.IsMaybe:
+			e.Kind = "optional"
+			e.IsOk = true
+			if !isSeen {
+				e.Result = "not seen"
+			}
+		default:
+			e.IsOk = isSeen
+			if !isSeen {
+				e.Result = "missing"
+			}
+		}
+		for _, evt := range exp.Matches {
+			e.Matches = append(e.Matches, EventJson(evt))
+		}
+		for _, nm := range v.NearMisses(exp) {
+			summary := fmt.Sprintf("%d of %d field checks satisfied", nm.NumChecksSatisfied, nm.NumChecks)
+			for _, fc := range nm.FailedChecks {
+				summary += fmt.Sprintf("; %s%s%s, actual '%s'", fc.FieldName, fc.Op, fc.Expected, fc.Actual)
+			}
+			e.NearMisses = append(e.NearMisses, &HtmlNearMiss{Summary: summary, Event: EventJson(nm.Event)})
+		}
+		tool.Events = append(tool.Events, e)
+	}
+	for _, corr := range v.State.TestData.ExpectedCorrelations {
+		met := "NOT met"
+		if corr.IsMet {
+			met = "met"
+		}
+		tool.Correlations = append(tool.Correlations, fmt.Sprintf("%s %s %s %s : %s", corr.Id, corr.Type, corr.SubType, strings.Join(corr.EventIndexes, ","), met))
+	}
+	for _, alert := range v.State.TestData.ExpectedAlerts {
+		detected := "NOT detected"
+		if len(alert.Matches) > 0 {
+			detected = "detected"
+		}
+		tool.Alerts = append(tool.Alerts, fmt.Sprintf("%s %s %s : %s", alert.Id, alert.Type, strings.Join(alert.Keywords, ","), detected))
+	}
+	return tool
+}
+
+/*
+ * NewHtmlReport returns the data of index.html for tests
+ */
+func NewHtmlReport(tests []*SingleTestRun) *HtmlReport {
+	report := &HtmlReport{Title: "atomic-harness " + filepath.Base(flagResultsPath), Missing: gTechniquesMissingTests}
+	counts := map[string]int{}
+
+	for _, testRun := range tests {
+		t := &HtmlTest{Technique: testRun.criteria.Technique, TestIndex: testRun.criteria.TestIndex, Name: testRun.criteria.TestName}
+		t.Anchor = fmt.Sprintf("%s_%d", t.Technique, t.TestIndex)
+		t.State = testRun.state.String()
+		t.Status = testRun.status.String()
+		t.StatusClass = StatusClass(testRun.status)
+		t.MatchString = strings.TrimSpace(SPrintToolColumns(testRun))
+		if detected, numAlerts := CombineDetections(testRun); numAlerts > 0 {
+			t.Detections = fmt.Sprintf("Detect:%d/%d", detected, numAlerts)
+		}
+		for _, v := range testRun.validators {
+			t.Tools = append(t.Tools, NewHtmlTool(v, len(testRun.validators) > 1))
+		}
+		if data, err := os.ReadFile(filepath.FromSlash(testRun.resultsDir + "/runner-stdout.txt")); err == nil {
+			t.Stdout = string(data)
+		}
+		report.Tests = append(report.Tests, t)
+		counts[t.Status] += 1
+	}
+
+	for status, n := range counts {
+		report.StatusCounts = append(report.StatusCounts, fmt.Sprintf("%s:%d", status, n))
+	}
+	sort.Strings(report.StatusCounts)
+	return report
+}
+
+/*
+ * WriteHtmlReport writes html report of tests to outPath
+ */
+func WriteHtmlReport(tests []*SingleTestRun, outPath string) {
+	f, err := os.Create(outPath)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+		return
+	}
+	defer f.Close()
+
+	err = kHtmlReportTemplate.Execute(f, NewHtmlReport(tests))
+	if err != nil {
+		fmt.Println("ERROR: failed to write html report", outPath, err)
+	}
+}
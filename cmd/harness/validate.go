@@ -2,30 +2,48 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	types "github.com/secureworks/atomic-harness/pkg/types"
 	utils "github.com/secureworks/atomic-harness/pkg/utils"
 )
 
 type ExtractState struct {
-	StartTime   uint64                  `json:"start_time"`
-	EndTime     uint64                  `json:"end_time"`
-	TestData    types.MitreTestCriteria `json:"test_data"`
-	TotalEvents uint64                  `json:"total_events"`
-	NumMatches  uint64                  `json:"num_matches"`
-	Coverage    float64                 `json:"coverage"`
-	MatchingTag string                  `json:"matching_tag",omitempty`
+	StartTime          uint64                  `json:"start_time"`
+	EndTime            uint64                  `json:"end_time"`
+	TestData           types.MitreTestCriteria `json:"test_data"`
+	TotalEvents        uint64                  `json:"total_events"`
+	NumMatches         uint64                  `json:"num_matches"`
+	NumUnexpected      uint64                  `json:"num_unexpected,omitempty"` // matched _N_ events
+	NumOptional        uint64                  `json:"num_optional,omitempty"`   // _?_ events, not part of coverage
+	NumOptionalMatched uint64                  `json:"num_optional_matched,omitempty"`
+	OptionalMissing    []string                `json:"optional_missing,omitempty"` // ids of unmatched _?_ events
+	Coverage           float64                 `json:"coverage"`
+	MatchingTag        string                  `json:"matching_tag,omitempty"`
+	NumAlerts          uint64                  `json:"num_alerts,omitempty"` // _A_ rows, not part of coverage
+	NumAlertsDetected  uint64                  `json:"num_alerts_detected,omitempty"`
+	AlertsMissing      []string                `json:"alerts_missing,omitempty"` // ids of undetected _A_ rows
+
+	// --telemetrytimeout polling, see RecordLatency()
+	NumPolls             int   `json:"num_polls,omitempty"`
+	TimeToFullCoverageMs int64 `json:"time_to_full_coverage_ms,omitempty"` // of this tool, from end of test.  Per event is time_to_first_match_ms
 }
 
-var (
-	gValidateState = ExtractState{}
+// events this long before StartTime or after EndTime of a test are still dispatched to it
+var kEventWindowSlackNs = int64(5 * time.Second)
 
+var (
 	// sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-test.bash
 	gRxGoArtStage = regexp.MustCompile(`sh /tmp/(artwork-T[\w-_\.\d]+)/goart-(T[\d\._]+)-(\w+)`)
 
@@ -34,49 +52,236 @@ var (
 	gRxGoArtStageWin = regexp.MustCompile(`(POWERSHELL |CMD /c |pwsh ).*\\(artwork-T[\w-_\.\d]+)\\goart-(T[\d\._]+)-(\w+)`)
 )
 
-func CheckMatch(haystack, op, needle string) bool {
+/**
+ * CheckFieldMatch returns true if haystack satisfies field check.
+ * See pkg/utils/fieldcheck.go for the operators.
+ */
+func CheckFieldMatch(haystack string, fc *types.FieldCriteria) bool {
 	if gDebug {
-		fmt.Println("CheckMatch", op, "\""+haystack+"\"", needle)
-	}
-	switch op {
-	case "=":
-		return haystack == needle
-	case "~=":
-		return strings.Contains(haystack, needle)
-	case "*=":
-		// TODO: only want to compile this once
-		rx, err := regexp.Compile(needle)
-		if err != nil {
-			fmt.Println("invalid regex", needle, err)
-			return false
-		}
-		return rx.MatchString(haystack)
-	case "!=":
-		return haystack != needle
-	default:
-		fmt.Println("ERROR: unsupported operator", op)
+		fmt.Println("CheckFieldMatch", fc.Op, "\""+haystack+"\"", fc.Value)
 	}
-	return false
+	return utils.MatchFieldCriteria(haystack, fc)
 }
 
-func AddMatchingEvent(testRun *SingleTestRun, exp *types.ExpectedEvent, event *types.SimpleEvent) {
+func AddMatchingEvent(v *Validator, exp *types.ExpectedEvent, event *types.SimpleEvent) {
 	exp.Matches = append(exp.Matches, event)
-	gValidateState.NumMatches += 1
-	UpdateCoverage()
+	v.State.NumMatches += 1
+	UpdateCoverage(&v.State)
+}
+
+// number of near misses kept per expected event
+var kMaxNearMisses = 5
+
+/**
+ * AddNearMiss keeps event as a candidate for expected event if it
+ * satisfied at least one field check, and is among the kMaxNearMisses
+ * that satisfied the most.  On a tie, the earlier event is kept.
+ */
+func AddNearMiss(v *Validator, exp *types.ExpectedEvent, event *types.SimpleEvent, numChecksSatisfied int) {
+	if numChecksSatisfied <= 0 {
+		return
+	}
+	if v.nearMisses == nil {
+		v.nearMisses = map[*types.ExpectedEvent][]*NearMiss{}
+	}
+	a := v.nearMisses[exp]
+	i := len(a)
+	for i > 0 && a[i-1].NumChecksSatisfied < numChecksSatisfied {
+		i -= 1
+	}
+	if i >= kMaxNearMisses {
+		return
+	}
+	nm := &NearMiss{Event: event, NumChecksSatisfied: numChecksSatisfied, NumChecks: len(exp.FieldChecks)}
+	a = append(a[:i], append([]*NearMiss{nm}, a[i:]...)...)
+	if len(a) > kMaxNearMisses {
+		a = a[:kMaxNearMisses]
+	}
+	v.nearMisses[exp] = a
+}
+
+/**
+ * NearMisses returns candidates for expected event, if it was not matched
+ */
+func (v *Validator) NearMisses(exp *types.ExpectedEvent) []*NearMiss {
+	if len(exp.Matches) > 0 {
+		return nil
+	}
+	a := v.nearMisses[exp]
+	for _, nm := range a {
+		if nm.FailedChecks == nil {
+			ExplainNearMiss(exp, nm)
+		}
+	}
+	return a
+}
+
+/**
+ * ExplainNearMiss sets the field checks of expected event that the
+ * event did not satisfy, with actual values.  Exit fields of process
+ * events have no actual value, as they are on the exit event.
+ */
+func ExplainNearMiss(exp *types.ExpectedEvent, nm *NearMiss) {
+	nm.FailedChecks = []FailedCheck{}
+	fields := EventFieldValues(nm.Event)
+	for _, fc := range exp.FieldChecks {
+		if IsFieldCheckSatisfied(nm.Event, fields, &fc) {
+			continue
+		}
+		nm.FailedChecks = append(nm.FailedChecks, FailedCheck{FieldName: fc.FieldName, Op: fc.Op, Expected: fc.Value, Actual: fields[fc.FieldName]})
+	}
+}
+
+/**
+ * IsFieldCheckSatisfied evaluates fc against fields of evt from
+ * EventFieldValues().  File events use CheckFileFieldMatch(), as
+ * CheckFileEvent() does.
+ */
+func IsFieldCheckSatisfied(evt *types.SimpleEvent, fields map[string]string, fc *types.FieldCriteria) bool {
+	if evt.FileFields != nil {
+		return CheckFileFieldMatch(evt.FileFields, fc)
+	}
+	value, ok := fields[fc.FieldName]
+	if !ok {
+		return false
+	}
+	return CheckFieldMatch(value, fc)
+}
+
+/**
+ * WriteNearMisses saves candidates of each unmatched expected event,
+ * other than negated ones, to near_misses.json of test.
+ */
+func WriteNearMisses(v *Validator) {
+	reports := []*NearMissReport{}
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		if exp.IsNegated || len(exp.Matches) > 0 {
+			continue
+		}
+		r := &NearMissReport{Id: exp.Id, EventType: exp.EventType, SubType: exp.SubType, FieldChecks: exp.FieldChecks, IsMaybe: exp.IsMaybe}
+		r.Candidates = v.NearMisses(exp)
+		if r.Candidates == nil {
+			r.Candidates = []*NearMiss{}
+		}
+		reports = append(reports, r)
+	}
+	jb, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		fmt.Println("failed to encode near misses json", err)
+		return
+	}
+	outPath := v.testRun.resultsDir + "/near_misses" + v.tool.Suffix + ".json"
+	err = os.WriteFile(outPath, jb, 0644)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+	}
+}
+
+/**
+ * IsProcessExitField returns true for process field checks that are
+ * evaluated on the exit event of the process.
+ */
+func IsProcessExitField(fieldName string) bool {
+	return "exit_code" == fieldName || "duration_ms" == fieldName
+}
+
+/**
+ * CheckProcessExitEvent evaluates exit_code and duration_ms field checks
+ * of process start events waiting for exit of the same process.
+ * Duration is from the start to exit timestamps, in milliseconds.
+ * The start event is added as the match.
+ */
+func CheckProcessExitEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	retval := false
+	exit := evt.ProcessExitFields
+	pending, ok := v.pendingExits[exit.Pid]
+	if !ok {
+		return retval
+	}
+	remaining := []*PendingExit{}
+	for _, p := range pending {
+		startUniquePid := p.start.ProcessFields.UniquePid
+		if len(exit.UniquePid) > 0 && len(startUniquePid) > 0 && exit.UniquePid != startUniquePid {
+			remaining = append(remaining, p)
+			continue
+		}
+		durationMs := int64(0)
+		if evt.Timestamp > 0 && p.start.Timestamp > 0 {
+			durationMs = (evt.Timestamp - p.start.Timestamp) / int64(time.Millisecond)
+		}
+		fields := map[string]string{
+			"exit_code":   fmt.Sprintf("%d", exit.ExitCode),
+			"duration_ms": fmt.Sprintf("%d", durationMs),
+		}
+		numMatchingChecks := 0
+		numExitChecks := 0
+		for _, fc := range p.exp.FieldChecks {
+			if !IsProcessExitField(fc.FieldName) {
+				continue
+			}
+			numExitChecks += 1
+			if CheckFieldMatch(fields[fc.FieldName], &fc) {
+				numMatchingChecks += 1
+			}
+		}
+		if numMatchingChecks == numExitChecks {
+			AddMatchingEvent(v, p.exp, p.start)
+			if v.matchFile != nil {
+				fmt.Fprintln(v.matchFile, p.startRaw)
+			}
+			retval = true
+		} else {
+			AddNearMiss(v, p.exp, p.start, len(p.exp.FieldChecks)-numExitChecks+numMatchingChecks)
+			if gDebug {
+				fmt.Printf("ONLY %d of %d exit FieldChecks satisfied\n%s\n", numMatchingChecks, numExitChecks, nativeJsonStr)
+			}
+		}
+	}
+	if len(remaining) > 0 {
+		v.pendingExits[exit.Pid] = remaining
+	} else {
+		delete(v.pendingExits, exit.Pid)
+	}
+	return retval
 }
 
-func CheckProcessEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+func CheckProcessEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
+	if evt.ProcessFields == nil {
+		if evt.ProcessExitFields != nil {
+			return CheckProcessExitEvent(v, evt, nativeJsonStr)
+		}
+		return retval
+	}
 
 	// by default, filter out anything that is not in the actual ATR test
 	// by looking for goartrun 'test' shell process event
 
-	if flagFilterByGoartrunShell {
-		if IsGoArtStage(testRun, evt.ProcessFields.Cmdline, evt.Timestamp) {
-			testRun.ShellPid = evt.ProcessFields.Pid
+	if flagFilterByGoartrunShell || UseTreeAttribution() {
+		if IsGoArtStage(v, evt.ProcessFields.Cmdline, evt.Timestamp) {
+			if v.TimeOfParentShell == evt.Timestamp && 0 == v.TimeOfNextStage {
+				// test shell is the root of process tree
+				v.ShellPid = evt.ProcessFields.Pid
+				v.processTree = NewProcessTree()
+				v.processTree.Add(evt.ProcessFields.Pid, evt.ProcessFields.UniquePid)
+			}
+			return retval
+		}
+	}
+
+	if UseTreeAttribution() {
+		UpdateProcessTree(v.processTree, evt.ProcessFields)
+		if !v.processTree.Contains(evt.ProcessFields.Pid, evt.ProcessFields.UniquePid) {
+			// parent may arrive later, see ResolveHeldEvents()
+			if IsInTestWindow(v, evt.Timestamp) {
+				v.heldEvents = append(v.heldEvents, &HeldEvent{evt, nativeJsonStr})
+			}
 			return retval
 		}
-		if 0 == testRun.TimeOfParentShell || 0 != testRun.TimeOfNextStage {
+	}
+
+	if flagFilterByGoartrunShell && UseTimeAttribution() {
+		if 0 == v.TimeOfParentShell || 0 != v.TimeOfNextStage {
 			if gVerbose {
 				fmt.Println("Ignoring event before/after ATR test", nativeJsonStr)
 			}
@@ -86,24 +291,28 @@ func CheckProcessEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 
 	// pull out expected process event criteria and match
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "Process" {
 			continue
 		}
 		numMatchingChecks := 0
+		numExitChecks := 0
 		for _, fc := range exp.FieldChecks {
 			isMatch := false
 			switch fc.FieldName {
+			case "exit_code", "duration_ms":
+				numExitChecks += 1
+				continue
 			case "cmdline":
-				isMatch = CheckMatch(evt.ProcessFields.Cmdline, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ProcessFields.Cmdline, &fc)
 			case "exepath":
-				isMatch = CheckMatch(evt.ProcessFields.ExePath, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ProcessFields.ExePath, &fc)
 			case "env":
-				isMatch = CheckMatch(evt.ProcessFields.Env, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ProcessFields.Env, &fc)
 			case "is_elevated":
-				isMatch = CheckMatch(BoolAsString(evt.ProcessFields.IsElevated), fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(BoolAsString(evt.ProcessFields.IsElevated), &fc)
 			case "hashes":
-				isMatch = CheckMatch(evt.ProcessFields.Hashes, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ProcessFields.Hashes, &fc)
 			default:
 				fmt.Println("ERROR: unknown FieldName", fc)
 			}
@@ -114,11 +323,20 @@ func CheckProcessEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 				numMatchingChecks += 1
 			}
 		}
-		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+		if numExitChecks > 0 && numMatchingChecks+numExitChecks == len(exp.FieldChecks) {
+			// match when process exits
+
+			if v.pendingExits == nil {
+				v.pendingExits = map[int64][]*PendingExit{}
+			}
+			pid := evt.ProcessFields.Pid
+			v.pendingExits[pid] = append(v.pendingExits[pid], &PendingExit{exp: exp, start: evt, startRaw: nativeJsonStr})
+		} else if numMatchingChecks == len(exp.FieldChecks) {
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -126,14 +344,98 @@ func CheckProcessEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 	return retval
 }
 
-func CheckFileEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+/**
+ * HasPermBits returns true if permFlags, in octal like "755" or
+ * symbolic like "rwxr-xr-x", has any of the bits of perms for user,
+ * group or other, e.g. "x" for any execute bit. 's' is setuid or setgid,
+ * 't' is sticky.
+ */
+func HasPermBits(permFlags string, perms string) bool {
+	mode := uint64(0)
+	if val, err := strconv.ParseUint(permFlags, 8, 32); err == nil {
+		mode = val
+	} else {
+		s := permFlags
+		if len(s) == 10 {
+			s = s[1:] // file type, e.g. "-rwxr-xr-x"
+		}
+		if len(s) != 9 {
+			return false
+		}
+		for i, c := range s {
+			bit := uint64(1) << (8 - i)
+			switch c {
+			case 'r', 'w', 'x':
+				mode |= bit
+			case 's':
+				mode |= bit | uint64(04000>>(i/3))
+			case 'S':
+				mode |= uint64(04000 >> (i / 3))
+			case 't':
+				mode |= bit | 01000
+			case 'T':
+				mode |= 01000
+			}
+		}
+	}
+
+	mask := uint64(0)
+	for _, c := range perms {
+		switch c {
+		case 'r':
+			mask |= 0444
+		case 'w':
+			mask |= 0222
+		case 'x':
+			mask |= 0111
+		case 's':
+			mask |= 06000
+		case 't':
+			mask |= 01000
+		}
+	}
+	return mask != 0 && mode&mask != 0
+}
+
+/**
+ * CheckFileFieldMatch evaluates fc against file event fields.  "path"
+ * matches either the target or dest path, and perm_flags=+x checks
+ * that any of the permission bits are set, see HasPermBits().
+ */
+func CheckFileFieldMatch(f *types.SimpleFileFields, fc *types.FieldCriteria) bool {
+	switch fc.FieldName {
+	case "path":
+		return CheckFieldMatch(f.TargetPath, fc) || CheckFieldMatch(f.DestPath, fc)
+	case "target_path":
+		return CheckFieldMatch(f.TargetPath, fc)
+	case "dest_path":
+		return CheckFieldMatch(f.DestPath, fc)
+	case "exe_path":
+		return CheckFieldMatch(f.ExePath, fc)
+	case "pid":
+		return CheckFieldMatch(fmt.Sprintf("%d", f.Pid), fc)
+	case "unique_pid":
+		return CheckFieldMatch(f.UniquePid, fc)
+	case "exit_code":
+		return CheckFieldMatch(fmt.Sprintf("%d", f.ExitCode), fc)
+	case "perm_flags":
+		if strings.HasPrefix(fc.Value, "+") && "=" == fc.Op {
+			return HasPermBits(f.PermFlags, fc.Value[1:])
+		}
+		return CheckFieldMatch(f.PermFlags, fc)
+	}
+	fmt.Println("ERROR: unknown FieldName", *fc)
+	return false
+}
+
+func CheckFileEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
-	if flagFilterFileEventsTmp {
-		if IsGoArtWorkDirEvent(testRun, evt) {
+	if flagFilterFileEventsTmp && UseTimeAttribution() {
+		if IsGoArtWorkDirEvent(v, evt) {
 			return retval
 		}
-		if 0 == testRun.TimeWorkDirCreate || 0 != testRun.TimeWorkDirDelete {
-			if 0 != testRun.TimeWorkDirDelete && evt.Timestamp <= testRun.TimeWorkDirDelete {
+		if 0 == v.TimeWorkDirCreate || 0 != v.TimeWorkDirDelete {
+			if 0 != v.TimeWorkDirDelete && evt.Timestamp <= v.TimeWorkDirDelete {
 				// we want this
 			} else {
 				if gVerbose {
@@ -144,7 +446,7 @@ func CheckFileEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 		}
 	}
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "File" {
 			continue
 		}
@@ -185,17 +487,7 @@ func CheckFileEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 
 		numMatchingChecks := 0
 		for _, fc := range exp.FieldChecks {
-			isMatch := false
-			switch fc.FieldName {
-			case "path":
-				isMatch = CheckMatch(evt.FileFields.TargetPath, fc.Op, fc.Value)
-				if !isMatch {
-					isMatch = CheckMatch(evt.FileFields.DestPath, fc.Op, fc.Value)
-				}
-			default:
-				fmt.Println("ERROR: unknown FieldName", fc)
-			}
-			if isMatch {
+			if CheckFileFieldMatch(evt.FileFields, &fc) {
 				if gVerbose {
 					fmt.Printf("Field Match '%s' '%s'\n", fc.FieldName, fc.Value)
 				}
@@ -203,10 +495,11 @@ func CheckFileEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 			}
 		}
 		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied.\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -214,63 +507,128 @@ func CheckFileEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 	return retval
 }
 
-func CheckNetflowEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+/**
+ * FlowFields are the parts of netflow FlowStr and FlowStrDns
+ */
+type FlowFields struct {
+	Proto   string
+	SrcIp   string
+	SrcPort string
+	DstIp   string
+	DstPort string
+	DstHost string // from FlowStrDns, if present
+}
+
+/**
+ * SplitHostPort splits "ip:port" on the last colon, so IPv6 addresses
+ * are supported with or without square brackets.
+ */
+func SplitHostPort(s string) (string, string) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, ""
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(s[:i], "["), "]")
+	return host, s[i+1:]
+}
+
+/**
+ * ParseFlowStr parses FlowStr 'proto:ip:port->ip:port', e.g.
+ * "tcp:10.0.0.5:41514->10.0.0.1:22". The host of FlowStrDns
+ * 'proto:ip:port->host:port' is DstHost.  Proto is lowercase.
+ */
+func ParseFlowStr(flowStr string, flowStrDns string) (*FlowFields, error) {
+	a := strings.SplitN(flowStr, "->", 2)
+	i := strings.Index(a[0], ":")
+	if len(a) != 2 || i < 0 {
+		return nil, fmt.Errorf("invalid flow_str: %s", flowStr)
+	}
+	retval := &FlowFields{Proto: strings.ToLower(a[0][:i])}
+	retval.SrcIp, retval.SrcPort = SplitHostPort(a[0][i+1:])
+	retval.DstIp, retval.DstPort = SplitHostPort(a[1])
+
+	if b := strings.SplitN(flowStrDns, "->", 2); len(b) == 2 {
+		retval.DstHost, _ = SplitHostPort(b[1])
+	}
+	return retval, nil
+}
+
+/**
+ * IsLegacyNetflowMatch returns true if either flow string matches
+ * SubType of expected event, which has '*' wildcards, e.g.
+ * "TCP:*->victim-host:22".  Comparison is case-insensitive.  The
+ * pattern is compiled when criteria is loaded.
+ */
+func IsLegacyNetflowMatch(exp *types.ExpectedEvent, netflow *types.SimpleNetflowFields) bool {
+	rx := exp.SubTypeRegex
+	if rx == nil {
+		// criteria not loaded from file
+		cp := *exp
+		if err := utils.CompileSubType(&cp); err != nil || cp.SubTypeRegex == nil {
+			fmt.Println("Invalid netflow pattern", exp.SubType, err)
+			return false
+		}
+		rx = cp.SubTypeRegex
+	}
+	if rx.MatchString(netflow.FlowStr) {
+		return true
+	}
+	return len(netflow.FlowStrDns) > 0 && rx.MatchString(netflow.FlowStrDns)
+}
+
+/**
+ * CheckNetflowEvent fields: proto, src_ip, src_port, dst_ip, dst_port,
+ * dst_host, flags, pid, exe_path.  All field checks and the legacy
+ * SubType pattern, if present, must match.
+ */
+func CheckNetflowEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.NetflowFields == nil {
+		return false
+	}
 	retval := false
-	for _, exp := range gValidateState.TestData.ExpectedEvents {
+	f := evt.NetflowFields
+	fields := EventFieldValues(evt)
+
+	for _, exp := range v.State.TestData.ExpectedEvents {
 
 		if strings.ToUpper(exp.EventType) != "NETFLOW" {
 			continue
 		}
-
-		// make regexes from subtype and all fieldchecks
-
-		s := strings.ReplaceAll(exp.SubType, "*", ".*")
-		rx, err := regexp.Compile(strings.ToLower(s))
-		if err != nil {
-			fmt.Println("Invalid netflow regex", exp.SubType, err)
+		if gVerbose {
+			fmt.Println("Netflow", f.FlowStr, exp.SubType)
+		}
+		if len(exp.SubType) > 0 && !IsLegacyNetflowMatch(exp, f) {
 			continue
 		}
 
-		regexes := []*regexp.Regexp{rx}
-
+		numMatchingChecks := 0
 		for _, fc := range exp.FieldChecks {
-			s := strings.ReplaceAll(fc.Value, "*", ".*")
-			rx, err := regexp.Compile(strings.ToLower(s))
-			if err != nil {
-				fmt.Println("Invalid netflow regex", fc, err)
+			value, ok := fields[fc.FieldName]
+			if !ok {
+				fmt.Println("ERROR: unknown FieldName", fc)
+				continue
 			}
-			regexes = append(regexes, rx)
-		}
-
-		// now check against FlowStr, FlowStrDns
-
-		if gVerbose {
-			fmt.Println("Netflow", evt.NetflowFields.FlowStr, exp.SubType)
-		}
-		for _, rx := range regexes {
-			matched := rx.MatchString(evt.NetflowFields.FlowStr)
-			if matched {
-				AddMatchingEvent(testRun, exp, evt)
-				retval = true
-				break
+			if CheckFieldMatch(value, &fc) {
+				numMatchingChecks += 1
 			}
-			if len(evt.NetflowFields.FlowStrDns) > 0 {
-				matched = rx.MatchString(evt.NetflowFields.FlowStrDns)
-				if matched {
-					AddMatchingEvent(testRun, exp, evt)
-					retval = true
-					break
-				}
+		}
+		if numMatchingChecks == len(exp.FieldChecks) {
+			AddMatchingEvent(v, exp, evt)
+			retval = true
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
+				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
 	}
 	return retval
 }
 
-func CheckETWEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+func CheckETWEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "ETW" {
 			continue
 		}
@@ -279,11 +637,11 @@ func CheckETWEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 			isMatch := false
 			switch fc.FieldName {
 			case "chan_name":
-				isMatch = CheckMatch(evt.ETWFields.ChanName, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ETWFields.ChanName, &fc)
 			case "event_msg":
-				isMatch = CheckMatch(evt.ETWFields.EventMsg, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ETWFields.EventMsg, &fc)
 			case "event_data_list":
-				isMatch = CheckMatch(evt.ETWFields.EvtData, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.ETWFields.EvtData, &fc)
 			default:
 				fmt.Println("ERROR: unknown FieldName", fc)
 			}
@@ -295,10 +653,11 @@ func CheckETWEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 			}
 		}
 		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -306,10 +665,10 @@ func CheckETWEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 	return retval
 }
 
-func CheckAMSIEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+func CheckAMSIEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "AMSI" {
 			continue
 		}
@@ -319,9 +678,9 @@ func CheckAMSIEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 			isMatch := false
 			switch fc.FieldName {
 			case "app_name":
-				isMatch = CheckMatch(evt.AMSIFields.AppName, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.AMSIFields.AppName, &fc)
 			case "scan_content":
-				isMatch = CheckMatch(evt.AMSIFields.ScanContent, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.AMSIFields.ScanContent, &fc)
 			default:
 				fmt.Println("ERROR: unknown FieldName", fc)
 			}
@@ -333,10 +692,11 @@ func CheckAMSIEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 			}
 		}
 		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -346,10 +706,10 @@ func CheckAMSIEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonSt
 
 }
 
-func CheckRegEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+func CheckRegEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "REG" {
 			continue
 		}
@@ -359,13 +719,13 @@ func CheckRegEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 			isMatch := false
 			switch fc.FieldName {
 			case "event_type":
-				isMatch = CheckMatch(evt.RegFields.EventType, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.RegFields.EventType, &fc)
 			case "key_name":
-				isMatch = CheckMatch(evt.RegFields.KeyName, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.RegFields.KeyName, &fc)
 			case "value_name":
-				isMatch = CheckMatch(evt.RegFields.ValueName, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.RegFields.ValueName, &fc)
 			case "value_data":
-				isMatch = CheckMatch(evt.RegFields.ValueData, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.RegFields.ValueData, &fc)
 			default:
 				fmt.Println("ERROR: unknown FieldName", fc)
 			}
@@ -377,10 +737,11 @@ func CheckRegEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 			}
 		}
 		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -390,10 +751,10 @@ func CheckRegEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr
 
 }
 
-func CheckApiCallEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJsonStr string) bool {
+func CheckApiCallEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
 	retval := false
 
-	for _, exp := range testRun.criteria.ExpectedEvents {
+	for _, exp := range v.State.TestData.ExpectedEvents {
 		if exp.EventType != "API" {
 			continue
 		}
@@ -403,13 +764,13 @@ func CheckApiCallEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 			isMatch := false
 			switch fc.FieldName {
 			case "function_called":
-				isMatch = CheckMatch(evt.APIFields.FunctionCalled, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.APIFields.FunctionCalled, &fc)
 			case "was_operation_successful":
-				isMatch = CheckMatch(BoolAsString(evt.APIFields.WasOperationSuccessful), fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(BoolAsString(evt.APIFields.WasOperationSuccessful), &fc)
 			case "parameter_names":
-				isMatch = CheckMatch(evt.APIFields.ParameterNames, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.APIFields.ParameterNames, &fc)
 			case "parameter_values":
-				isMatch = CheckMatch(evt.APIFields.ParameterValues, fc.Op, fc.Value)
+				isMatch = CheckFieldMatch(evt.APIFields.ParameterValues, &fc)
 			default:
 				fmt.Println("ERROR: unknown FieldName", fc)
 			}
@@ -421,10 +782,11 @@ func CheckApiCallEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 			}
 		}
 		if numMatchingChecks == len(exp.FieldChecks) {
-			AddMatchingEvent(testRun, exp, evt)
+			AddMatchingEvent(v, exp, evt)
 			retval = true
-		} else if numMatchingChecks > 0 {
-			if gDebug {
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
 				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
 			}
 		}
@@ -434,154 +796,1101 @@ func CheckApiCallEvent(testRun *SingleTestRun, evt *types.SimpleEvent, nativeJso
 
 }
 
-func ValidateSimpleTelemetry(testRun *SingleTestRun, tool *TelemTool) {
-	gValidateState = ExtractState{}
-	gValidateState.StartTime = uint64(testRun.StartTime)
-	gValidateState.EndTime = uint64(testRun.EndTime)
-	gValidateState.TestData.Technique = testRun.criteria.Technique
-	gValidateState.TestData.TestIndex = testRun.criteria.TestIndex
-	gValidateState.TestData.TestName = testRun.criteria.TestName
-	gValidateState.TestData.ExpectedEvents = testRun.criteria.ExpectedEvents
-
-	// load simple_telemetry.json, process each event
-
-	path := testRun.resultsDir + "/../simple_telemetry" + tool.Suffix + ".json"
-	simpleLines, err := ReadFileLines(path)
-	if err != nil {
-		fmt.Println("ERROR: file not found", path, err)
-		return
+/**
+ * MatchesSubType returns true if SubType of expected event is empty or
+ * '*', or matches value of event case-insensitively, with '*' wildcards.
+ * The wildcard pattern is compiled when criteria is loaded.
+ */
+func MatchesSubType(exp *types.ExpectedEvent, value string) bool {
+	if len(exp.SubType) == 0 || exp.SubType == "*" {
+		return true
 	}
-
-	path = testRun.resultsDir + "/../telemetry" + tool.Suffix + ".json"
-	rawJsonLines, err := ReadFileLines(path)
-	if err != nil {
-		fmt.Println("ERROR: file not found", path, err)
-		return
+	if !strings.Contains(exp.SubType, "*") {
+		return strings.EqualFold(exp.SubType, value)
 	}
-	if len(simpleLines) != len(rawJsonLines) {
-		fmt.Println("ERROR: num simple does not match num raw", len(simpleLines), len(rawJsonLines))
-		return
+	rx := exp.SubTypeRegex
+	if rx == nil {
+		// criteria not loaded from file
+		cp := *exp
+		if err := utils.CompileSubType(&cp); err != nil || cp.SubTypeRegex == nil {
+			fmt.Println("ERROR: invalid subtype", exp.SubType, err)
+			return false
+		}
+		rx = cp.SubTypeRegex
 	}
+	return rx.MatchString(value)
+}
 
-	// write native telemetry matches to a file
-	outpath := testRun.resultsDir + "/matches" + tool.Suffix + ".json"
-	matchFileHandle, err := os.OpenFile(outpath, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println("ERROR: unable to create outfile", outpath, err)
+/**
+ * EventFieldValues returns the values of fields of evt by the names
+ * used in field checks of its event type.  For file events, "path" is
+ * the target_path.
+ */
+func EventFieldValues(evt *types.SimpleEvent) map[string]string {
+	switch {
+	case evt.DetectionFields != nil:
+		f := evt.DetectionFields
+		return map[string]string{
+			"rule_name": f.RuleName,
+			"rule_id":   f.RuleId,
+			"severity":  f.Severity,
+			"message":   f.Message,
+			"exe_path":  f.ExePath,
+		}
+	case evt.ProcessFields != nil:
+		f := evt.ProcessFields
+		return map[string]string{
+			"cmdline":     f.Cmdline,
+			"exepath":     f.ExePath,
+			"env":         f.Env,
+			"is_elevated": BoolAsString(f.IsElevated),
+			"hashes":      f.Hashes,
+		}
+	case evt.FileFields != nil:
+		f := evt.FileFields
+		return map[string]string{
+			"path":        f.TargetPath,
+			"target_path": f.TargetPath,
+			"dest_path":   f.DestPath,
+			"exe_path":    f.ExePath,
+			"pid":         fmt.Sprintf("%d", f.Pid),
+			"unique_pid":  f.UniquePid,
+			"exit_code":   fmt.Sprintf("%d", f.ExitCode),
+			"perm_flags":  f.PermFlags,
+		}
+	case evt.NetflowFields != nil:
+		f := evt.NetflowFields
+		flow, err := ParseFlowStr(f.FlowStr, f.FlowStrDns)
+		if err != nil {
+			if gVerbose {
+				fmt.Println(err)
+			}
+			flow = &FlowFields{}
+		}
+		return map[string]string{
+			"proto":    flow.Proto,
+			"src_ip":   flow.SrcIp,
+			"src_port": flow.SrcPort,
+			"dst_ip":   flow.DstIp,
+			"dst_port": flow.DstPort,
+			"dst_host": flow.DstHost,
+			"flags":    f.Flags,
+			"pid":      fmt.Sprintf("%d", f.Pid),
+			"exe_path": f.ExePath,
+		}
+	case evt.ETWFields != nil:
+		f := evt.ETWFields
+		return map[string]string{
+			"chan_name":       f.ChanName,
+			"event_msg":       f.EventMsg,
+			"event_data_list": f.EvtData,
+		}
+	case evt.AMSIFields != nil:
+		f := evt.AMSIFields
+		return map[string]string{
+			"app_name":     f.AppName,
+			"scan_content": f.ScanContent,
+		}
+	case evt.RegFields != nil:
+		f := evt.RegFields
+		return map[string]string{
+			"event_type": f.EventType,
+			"key_name":   f.KeyName,
+			"value_name": f.ValueName,
+			"value_data": f.ValueData,
+		}
+	case evt.APIFields != nil:
+		f := evt.APIFields
+		return map[string]string{
+			"function_called":          f.FunctionCalled,
+			"was_operation_successful": BoolAsString(f.WasOperationSuccessful),
+			"parameter_names":          f.ParameterNames,
+			"parameter_values":         f.ParameterValues,
+		}
+	case evt.AuthFields != nil:
+		f := evt.AuthFields
+		return map[string]string{
+			"action":          f.Action,
+			"is_success":      BoolAsString(f.IsSuccess),
+			"username":        f.Username,
+			"target_username": f.TargetUsername,
+			"service":         f.Service,
+			"remote_addr":     f.RemoteAddr,
+			"exe_path":        f.ExePath,
+		}
+	case evt.ModuleFields != nil:
+		f := evt.ModuleFields
+		return map[string]string{
+			"action":   f.Action,
+			"name":     f.Name,
+			"path":     f.Path,
+			"hashes":   f.Hashes,
+			"exe_path": f.ExePath,
+		}
+	case evt.VolumeFields != nil:
+		f := evt.VolumeFields
+		return map[string]string{
+			"action":      f.Action,
+			"device_path": f.DevicePath,
+			"mount_path":  f.MountPath,
+			"fs_type":     f.FsType,
+			"options":     f.Options,
+			"exe_path":    f.ExePath,
+		}
+	case evt.PTraceFields != nil:
+		f := evt.PTraceFields
+		return map[string]string{
+			"request":         f.Request,
+			"target_pid":      fmt.Sprintf("%d", f.TargetPid),
+			"target_exe_path": f.TargetExePath,
+			"exe_path":        f.ExePath,
+		}
+	case evt.NetsniffFields != nil:
+		f := evt.NetsniffFields
+		return map[string]string{
+			"action":    f.Action,
+			"protocol":  f.Protocol,
+			"interface": f.Interface,
+			"exe_path":  f.ExePath,
+		}
 	}
+	return map[string]string{}
+}
 
-	for i, line := range simpleLines {
-		evt := &types.SimpleEvent{}
+/**
+ * CheckEventFields matches evt against expected events of eventType,
+ * using the values of event fields by name.  Shared by checkers of event
+ * types where SubType of expected event matches subType of event.
+ */
+func CheckEventFields(v *Validator, evt *types.SimpleEvent, nativeJsonStr string, eventType string, subType string, fields map[string]string) bool {
+	retval := false
 
-		err = json.Unmarshal([]byte(line), evt)
-		if err != nil {
-			fmt.Println("ERROR: parsing event", err, line)
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		if strings.ToUpper(exp.EventType) != eventType {
 			continue
 		}
-
-		rawEventStr := rawJsonLines[i]
-		isMatch := false
-
-		switch evt.EventType {
-		case types.SimpleSchemaProcess:
-			isMatch = CheckProcessEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaFilemod:
-			isMatch = CheckFileEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaFileRead:
-			isMatch = CheckFileEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaNetflow:
-			isMatch = CheckNetflowEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaETW:
-			isMatch = CheckETWEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaAMSI:
-			isMatch = CheckAMSIEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaReg:
-			isMatch = CheckRegEvent(testRun, evt, rawEventStr)
-		case types.SimpleSchemaAPI:
-			isMatch = CheckApiCallEvent(testRun, evt, rawEventStr)
-		default:
-			fmt.Println("missing handling of type", line)
+		if !MatchesSubType(exp, subType) {
+			continue
 		}
-		if isMatch && matchFileHandle != nil {
 
-			// write match to file
-
-			fmt.Fprintln(matchFileHandle, rawEventStr)
-
-			// did we get a technique match?
-			if 0 == len(gValidateState.MatchingTag) && len(evt.MitreTechniques) > 0 {
-				for _, tid := range evt.MitreTechniques {
-					if strings.HasPrefix(tid, testRun.criteria.Technique) {
-						gValidateState.MatchingTag = tid
-						testRun.HasMitreTag = true
-					}
+		numMatchingChecks := 0
+		for _, fc := range exp.FieldChecks {
+			isMatch := false
+			value, ok := fields[fc.FieldName]
+			if ok {
+				isMatch = CheckFieldMatch(value, &fc)
+			} else {
+				fmt.Println("ERROR: unknown FieldName", fc)
+			}
+			if isMatch {
+				if gDebug {
+					fmt.Printf("Field Match '%s' '%s'\n", fc.FieldName, fc.Value)
 				}
+				numMatchingChecks += 1
 			}
 		}
-	}
-
-	if matchFileHandle != nil {
-		matchFileHandle.Close()
-	}
+		if numMatchingChecks == len(exp.FieldChecks) {
+			AddMatchingEvent(v, exp, evt)
+			retval = true
+		} else {
+			AddNearMiss(v, exp, evt, numMatchingChecks)
+			if gDebug && numMatchingChecks > 0 {
+				fmt.Printf("ONLY %d of %d FieldChecks satisfied\n%s\n", numMatchingChecks, len(exp.FieldChecks), nativeJsonStr)
+			}
+		}
+	}
+	return retval
+}
 
-	// save results to file
+/**
+ * CheckAuthEvent fields: action, is_success, username, target_username,
+ * service, remote_addr, exe_path
+ */
+func CheckAuthEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.AuthFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "AUTH", "", EventFieldValues(evt))
+}
 
-	s := GetTelemTypes(&gValidateState.TestData)
-	outPath := testRun.resultsDir + "/match_string" + tool.Suffix + ".txt"
-	err = os.WriteFile(outPath, []byte(s), 0644)
-	if err != nil {
-		fmt.Println("ERROR: unable to write file", outPath, err)
+/**
+ * CheckModuleEvent SubType matches action. Fields: action, name, path,
+ * hashes, exe_path
+ */
+func CheckModuleEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.ModuleFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "MODULE", evt.ModuleFields.Action, EventFieldValues(evt))
+}
+
+/**
+ * CheckVolumeEvent fields: action, device_path, mount_path, fs_type,
+ * options, exe_path
+ */
+func CheckVolumeEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.VolumeFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "VOLUME", "", EventFieldValues(evt))
+}
+
+/**
+ * CheckPTraceEvent fields: request, target_pid, target_exe_path, exe_path
+ */
+func CheckPTraceEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.PTraceFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "PTRACE", "", EventFieldValues(evt))
+}
+
+/**
+ * CheckNetsniffEvent SubType matches action. Fields: action, protocol,
+ * interface, exe_path
+ */
+func CheckNetsniffEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.NetsniffFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "NETSNIFF", evt.NetsniffFields.Action, EventFieldValues(evt))
+}
+
+/**
+ * CheckDetectionEvent matches ALERT expected events. SubType matches
+ * rule_name. Fields: rule_name, rule_id, severity, message, exe_path
+ */
+func CheckDetectionEvent(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool {
+	if evt.DetectionFields == nil {
+		return false
+	}
+	return CheckEventFields(v, evt, nativeJsonStr, "ALERT", evt.DetectionFields.RuleName, EventFieldValues(evt))
+}
+
+type EventChecker func(v *Validator, evt *types.SimpleEvent, nativeJsonStr string) bool
+
+/**
+ * GetEventChecker returns the matching function for the simple schema
+ * event type, or nil if not supported.
+ */
+func GetEventChecker(evtType types.SimpleSchemaChar) EventChecker {
+	switch evtType {
+	case types.SimpleSchemaProcess:
+		return CheckProcessEvent
+	case types.SimpleSchemaFilemod:
+		return CheckFileEvent
+	case types.SimpleSchemaFileRead:
+		return CheckFileEvent
+	case types.SimpleSchemaNetflow:
+		return CheckNetflowEvent
+	case types.SimpleSchemaETW:
+		return CheckETWEvent
+	case types.SimpleSchemaAMSI:
+		return CheckAMSIEvent
+	case types.SimpleSchemaReg:
+		return CheckRegEvent
+	case types.SimpleSchemaAPI:
+		return CheckApiCallEvent
+	case types.SimpleSchemaAuth:
+		return CheckAuthEvent
+	case types.SimpleSchemaModule:
+		return CheckModuleEvent
+	case types.SimpleSchemaVolume:
+		return CheckVolumeEvent
+	case types.SimpleSchemaPTrace:
+		return CheckPTraceEvent
+	case types.SimpleSchemaNetsniff:
+		return CheckNetsniffEvent
+	case types.SimpleSchemaDetection:
+		return CheckDetectionEvent
+	}
+	return nil
+}
+
+/**
+ * Validator holds the state of validating the telemetry of one tool
+ * against the criteria of one test.  Expected events and correlations
+ * are copied from the criteria, so matches never leak between tools,
+ * and validators share no state, allowing them to run concurrently.
+ */
+type Validator struct {
+	testRun *SingleTestRun
+	tool    *TelemTool
+
+	State  ExtractState
+	Status types.TestStatus
+
+	TimeOfParentShell int64 // determined using IsGoArtStage()
+	TimeOfNextStage   int64
+	ShellPid          int64
+	TimeWorkDirCreate int64
+	TimeWorkDirDelete int64
+	HasMitreTag       bool
+
+	processTree  *ProcessTree             // ShellPid and descendants, see --attribution
+	heldEvents   []*HeldEvent             // not yet in processTree, see ResolveHeldEvents()
+	matchFile    *os.File                 // native telemetry of matching events
+	pendingExits map[int64][]*PendingExit // by pid, see CheckProcessExitEvent()
+	nearMisses   map[*types.ExpectedEvent][]*NearMiss
+}
+
+/**
+ * NearMiss is an event of the type of expected event that did not
+ * satisfy all of its field checks.
+ */
+type NearMiss struct {
+	NumChecksSatisfied int                `json:"num_checks_satisfied"`
+	NumChecks          int                `json:"num_checks"`
+	FailedChecks       []FailedCheck      `json:"failed_checks,omitempty"` // see ExplainNearMiss()
+	Event              *types.SimpleEvent `json:"event"`
+}
+
+/**
+ * FailedCheck is a field check of expected event and the actual value
+ * of the event.
+ */
+type FailedCheck struct {
+	FieldName string `json:"field"`
+	Op        string `json:"op"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+}
+
+/**
+ * NearMissReport lists the candidates of an unmatched expected event,
+ * saved in near_misses.json
+ */
+type NearMissReport struct {
+	Id          string                `json:"id"`
+	EventType   string                `json:"event_type"`
+	SubType     string                `json:"sub_type,omitempty"`
+	FieldChecks []types.FieldCriteria `json:"field_checks"`
+	IsMaybe     bool                  `json:"is_maybe,omitempty"`
+	Candidates  []*NearMiss           `json:"candidates"`
+}
+
+/**
+ * HeldEvent is an event outside of the process tree of the test when it
+ * was read.  Its process may be added to the tree by a later event.
+ */
+type HeldEvent struct {
+	evt         *types.SimpleEvent
+	rawEventStr string
+}
+
+/**
+ * PendingExit is a process start event that satisfied the field checks
+ * of expected event, other than those on its exit.
+ */
+type PendingExit struct {
+	exp      *types.ExpectedEvent
+	start    *types.SimpleEvent
+	startRaw string
+}
+
+/**
+ * NewValidator returns a validator for testRun and tool, with its own
+ * copy of the expected events and correlations of the test criteria.
+ */
+func NewValidator(testRun *SingleTestRun, tool *TelemTool) *Validator {
+	v := &Validator{testRun: testRun, tool: tool}
+	v.State.StartTime = uint64(testRun.StartTime)
+	v.State.EndTime = uint64(testRun.EndTime)
+	v.State.TestData.Technique = testRun.criteria.Technique
+	v.State.TestData.TestIndex = testRun.criteria.TestIndex
+	v.State.TestData.TestName = testRun.criteria.TestName
+
+	for _, exp := range testRun.criteria.ExpectedEvents {
+		cp := *exp
+		cp.Matches = nil
+		v.State.TestData.ExpectedEvents = append(v.State.TestData.ExpectedEvents, &cp)
+	}
+	for _, corr := range testRun.criteria.ExpectedCorrelations {
+		cp := *corr
+		cp.IsMet = false
+		v.State.TestData.ExpectedCorrelations = append(v.State.TestData.ExpectedCorrelations, &cp)
+	}
+	for _, alert := range testRun.criteria.ExpectedAlerts {
+		cp := *alert
+		cp.Matches = nil
+		v.State.TestData.ExpectedAlerts = append(v.State.TestData.ExpectedAlerts, &cp)
+	}
+	UpdateDetections(&v.State)
+	return v
+}
+
+/**
+ * IsEventForTest returns true if the event should be checked against
+ * the criteria of the test.  Process events are always needed to find
+ * goartrun stages and build process tree. Other events must be in the
+ * time window of the test, or in its process tree.
+ */
+func IsEventForTest(v *Validator, evt *types.SimpleEvent) bool {
+	if evt.EventType == types.SimpleSchemaProcess || evt.Timestamp == 0 {
+		return true
+	}
+	if IsInTestWindow(v, evt.Timestamp) {
+		return true
+	}
+	if evt.FileFields != nil && len(v.testRun.workingDir) > 0 && strings.Contains(evt.FileFields.TargetPath, filepath.Base(v.testRun.workingDir)) {
+		return true // goartrun working dir create and delete
+	}
+	return IsEventInProcessTree(v, evt)
+}
+
+/**
+ * IsInTestWindow returns true if tsNs is in the time window of the test,
+ * or the window is not known.
+ */
+func IsInTestWindow(v *Validator, tsNs int64) bool {
+	if 0 == v.testRun.StartTime || 0 == v.testRun.EndTime {
+		return true
+	}
+	return tsNs >= v.testRun.StartTime-kEventWindowSlackNs && tsNs <= v.testRun.EndTime+kEventWindowSlackNs
+}
+
+/**
+ * ValidateTestRuns validates the telemetry of each tool against the
+ * criteria of each test in testRuns, using flagValidateWorkers workers.
+ * A job is a tool and a shard of the tests, making a single pass through
+ * the telemetry files of the tool.  Validators and status of the tests
+ * are only updated, holding gStateLock, once all jobs are done.
+ */
+func ValidateTestRuns(testRuns []*SingleTestRun, tools []*TelemTool, telemetryDir string) {
+	if len(testRuns) == 0 || len(tools) == 0 {
+		return
+	}
+
+	validatorsOf := make([][]*Validator, len(testRuns))
+	for j, testRun := range testRuns {
+		validatorsOf[j] = make([]*Validator, len(tools))
+		for i, tool := range tools {
+			validatorsOf[j][i] = NewValidator(testRun, tool)
+		}
+	}
+
+	numWorkers := flagValidateWorkers
+	if numWorkers <= 0 {
+		numWorkers = len(tools)
+	}
+	numShards := (numWorkers + len(tools) - 1) / len(tools)
+	if numShards > len(testRuns) {
+		numShards = len(testRuns)
 	}
 
-	jb, err := json.MarshalIndent(gValidateState, "", "  ")
+	jobs := [][]*Validator{}
+	for i := range tools {
+		for shard := 0; shard < numShards; shard++ {
+			validators := []*Validator{}
+			for j := shard; j < len(testRuns); j += numShards {
+				validators = append(validators, validatorsOf[j][i])
+			}
+			jobs = append(jobs, validators)
+		}
+	}
+
+	queue := make(chan []*Validator)
+	var wg sync.WaitGroup
+
+	for i := 0; i < numWorkers && i < len(jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for validators := range queue {
+				ValidateSimpleTelemetry(validators, telemetryDir)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	gStateLock.Lock()
+	defer gStateLock.Unlock()
+
+	for j, testRun := range testRuns {
+		testRun.validators = validatorsOf[j]
+		statuses := []types.TestStatus{}
+		for _, v := range testRun.validators {
+			statuses = append(statuses, v.Status)
+			if v.HasMitreTag {
+				testRun.HasMitreTag = true
+			}
+		}
+		testRun.status = CombineToolStatus(statuses, flagCombine)
+	}
+}
+
+/**
+ * ValidateStatusRank orders validation status from worst to best.
+ * Seeing an event that must not occur is worse than seeing nothing.
+ */
+func ValidateStatusRank(status types.TestStatus) int {
+	switch status {
+	case types.StatusValidateSuccess:
+		return 3
+	case types.StatusValidatePartial:
+		return 2
+	case types.StatusValidateFail:
+		return 1
+	}
+	return 0
+}
+
+/**
+ * CombineToolStatus returns the status of a test given the status from
+ * each telemetry tool.  With policy 'any', the best status of any tool.
+ * With 'all' or 'columns', the worst, so a test is only validated if
+ * telemetry of all tools validated it.
+ */
+func CombineToolStatus(statuses []types.TestStatus, policy string) types.TestStatus {
+	if len(statuses) == 0 {
+		return types.StatusUnknown
+	}
+	retval := statuses[0]
+	for _, status := range statuses[1:] {
+		rank, current := ValidateStatusRank(status), ValidateStatusRank(retval)
+		if ("any" == policy && rank > current) || ("any" != policy && rank < current) {
+			retval = status
+		}
+	}
+	return retval
+}
+
+/**
+ * CombineDetections returns the number of _A_ rows of testRun detected
+ * and the total, combining tools using flagCombine like the status.
+ */
+func CombineDetections(testRun *SingleTestRun) (uint64, uint64) {
+	if len(testRun.validators) == 0 {
+		return 0, uint64(len(testRun.criteria.ExpectedAlerts))
+	}
+	detected := testRun.validators[0].State.NumAlertsDetected
+	for _, v := range testRun.validators[1:] {
+		n := v.State.NumAlertsDetected
+		if ("any" == flagCombine && n > detected) || ("any" != flagCombine && n < detected) {
+			detected = n
+		}
+	}
+	return detected, uint64(len(testRun.criteria.ExpectedAlerts))
+}
+
+/**
+ * MatchString returns the expected event types and whether they were
+ * found in the telemetry of the tool. See GetTelemTypes()
+ */
+func (v *Validator) MatchString() string {
+	return GetTelemTypes(&v.State.TestData)
+}
+
+/**
+ * ValidateSimpleTelemetry makes a single pass through the simple_telemetry
+ * and telemetry files in telemetryDir of the tool of validators, all of
+ * which must have the same tool.  Each event is checked against criteria
+ * of every test it belongs to.  Results are written to the resultsDir of
+ * each test, and validator status is set.
+ */
+func ValidateSimpleTelemetry(validators []*Validator, telemetryDir string) {
+	if len(validators) == 0 {
+		return
+	}
+	tool := validators[0].tool
+
+	reader, err := OpenTelemetryReader(telemetryDir, tool.Suffix)
 	if err != nil {
-		fmt.Println("failed to encode validation state json", err)
-	} else {
+		fmt.Println("ERROR: file not found", err)
+		return
+	}
+	defer reader.Close()
+
+	// write native telemetry matches to a file for each test
+
+	for _, v := range validators {
+		outpath := v.testRun.resultsDir + "/matches" + tool.Suffix + ".json"
+		v.matchFile, err = os.Create(outpath)
+		if err != nil {
+			fmt.Println("ERROR: unable to create outfile", outpath, err)
+		}
+	}
+
+	for {
+		line, rawLine, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("ERROR: reading telemetry", err)
+			break
+		}
 
-		outPath = testRun.resultsDir + "/validate_summary" + tool.Suffix + ".json"
-		err = os.WriteFile(outPath, jb, 0644)
+		evt := &types.SimpleEvent{}
+		err = json.Unmarshal(line, evt)
 		if err != nil {
-			fmt.Println("ERROR: unable to write file", outPath, err)
+			fmt.Println("ERROR: parsing event", err, string(line))
+			continue
+		}
+
+		checker := GetEventChecker(evt.EventType)
+		if checker == nil {
+			fmt.Println("missing handling of type", string(line))
+			continue
+		}
+		rawEventStr := string(rawLine)
+
+		for _, v := range validators {
+			if !IsEventForTest(v, evt) {
+				continue
+			}
+			v.State.TotalEvents += 1
+
+			if UseTreeAttribution() && evt.EventType != types.SimpleSchemaProcess && !IsEventInProcessTree(v, evt) {
+				// process may arrive later, see ResolveHeldEvents()
+				v.heldEvents = append(v.heldEvents, &HeldEvent{evt, rawEventStr})
+				continue
+			}
+
+			CheckEvent(v, evt, checker, rawEventStr)
+		}
+	}
+
+	for _, v := range validators {
+		ResolveHeldEvents(v)
+	}
+
+	if reader.NumMismatched > 0 {
+		fmt.Println("WARN: num simple does not match num raw events", reader.NumMismatched)
+	}
+
+	for _, v := range validators {
+		if v.matchFile != nil {
+			v.matchFile.Close()
+			v.matchFile = nil
 		}
+		FinishValidation(v)
+	}
+}
+
+/**
+ * CheckEvent checks evt against the criteria of the test of v, and
+ * writes the native event to the match file if it matched.
+ */
+func CheckEvent(v *Validator, evt *types.SimpleEvent, checker EventChecker, rawEventStr string) {
+	isMatch := checker(v, evt, rawEventStr)
+	if evt.DetectionFields != nil && CheckAlerts(v, evt) {
+		isMatch = true
 	}
+	if isMatch && v.matchFile != nil {
+
+		// write match to file
+
+		fmt.Fprintln(v.matchFile, rawEventStr)
+
+		// did we get a technique match?
+		if 0 == len(v.State.MatchingTag) {
+			if tid := FindTechniqueTag(evt, v.testRun.criteria.Technique); len(tid) > 0 {
+				v.State.MatchingTag = tid
+				v.HasMitreTag = true
+			}
+		}
+	}
+}
+
+/**
+ * ResolveHeldEvents is called after a pass through the telemetry, since
+ * telemetry is not always in time order.  Checks held events whose
+ * process, or for process events its parent, has since been added to
+ * the process tree, until no more are resolved.  The rest are ignored.
+ */
+func ResolveHeldEvents(v *Validator) {
+	for len(v.heldEvents) > 0 {
+		held := v.heldEvents
+		v.heldEvents = nil
+		numResolved := 0
+		for _, h := range held {
+			isInTree := IsEventInProcessTree(v, h.evt)
+			if h.evt.ProcessFields != nil {
+				isInTree = isInTree || v.processTree.Contains(h.evt.ProcessFields.ParentPid, h.evt.ProcessFields.ParentUniquePid)
+			}
+			if !isInTree {
+				v.heldEvents = append(v.heldEvents, h)
+				continue
+			}
+			CheckEvent(v, h.evt, GetEventChecker(h.evt.EventType), h.rawEventStr)
+			numResolved += 1
+		}
+		if numResolved == 0 {
+			break
+		}
+	}
+	if gDebug {
+		for _, h := range v.heldEvents {
+			fmt.Println("Ignoring event outside of test process tree", h.rawEventStr)
+		}
+	}
+	v.heldEvents = nil
+}
+
+/**
+ * FinishValidation is called after all events have been checked.
+ * Evaluates correlations, saves results and sets status of validator.
+ */
+func FinishValidation(v *Validator) {
+	state := &v.State
+
+	// correlations can only be evaluated once all events are matched
+
+	EvaluateCorrelations(&state.TestData)
+	UpdateCoverage(state)
+	UpdateDetections(state)
+
+	// save results to file
+
+	s := GetTelemTypes(&state.TestData)
+	outPath := v.testRun.resultsDir + "/match_string" + v.tool.Suffix + ".txt"
+	err := os.WriteFile(outPath, []byte(s), 0644)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+	}
+
+	WriteValidateSummary(v)
+	WriteNearMisses(v)
 
 	// set status based on coverage
-	// NOTE: with multiple telemtools, status will depend on last tool?
 
-	if gValidateState.Coverage == 1.0 {
-		testRun.status = types.StatusValidateSuccess
-	} else if gValidateState.Coverage == 0.0 {
-		testRun.status = types.StatusValidateFail
+	if state.Coverage == 1.0 {
+		v.Status = types.StatusValidateSuccess
+	} else if state.Coverage == 0.0 {
+		v.Status = types.StatusValidateFail
 	} else {
-		testRun.status = types.StatusValidatePartial
+		v.Status = types.StatusValidatePartial
+	}
+
+	// seeing an event that must not occur fails the test regardless of coverage
+
+	if state.NumUnexpected > 0 {
+		v.Status = types.StatusValidateUnexpected
 	}
 }
 
-func UpdateCoverage() {
-	numFound := 0
-	numExpected := len(gValidateState.TestData.ExpectedEvents) +
-		len(gValidateState.TestData.ExpectedCorrelations)
+/**
+ * WriteValidateSummary saves validation state to validate_summary.json
+ * in results dir of test.
+ */
+func WriteValidateSummary(v *Validator) {
+	jb, err := json.MarshalIndent(&v.State, "", "  ")
+	if err != nil {
+		fmt.Println("failed to encode validation state json", err)
+		return
+	}
+	outPath := v.testRun.resultsDir + "/validate_summary" + v.tool.Suffix + ".json"
+	err = os.WriteFile(outPath, jb, 0644)
+	if err != nil {
+		fmt.Println("ERROR: unable to write file", outPath, err)
+	}
+}
 
-	for _, exp := range gValidateState.TestData.ExpectedEvents {
+func UpdateCoverage(state *ExtractState) {
+	numFound := 0
+	numExpected := len(state.TestData.ExpectedCorrelations)
+	state.NumUnexpected = 0
+	state.NumOptional = 0
+	state.NumOptionalMatched = 0
+	state.OptionalMissing = nil
+
+	for _, exp := range state.TestData.ExpectedEvents {
+		if exp.IsNegated {
+			// not part of coverage
+			if len(exp.Matches) > 0 {
+				state.NumUnexpected += 1
+			}
+			continue
+		}
+		if exp.IsMaybe {
+			// optional, reported separately from coverage
+			state.NumOptional += 1
+			if len(exp.Matches) > 0 {
+				state.NumOptionalMatched += 1
+			} else {
+				state.OptionalMissing = append(state.OptionalMissing, exp.Id)
+			}
+			continue
+		}
+		numExpected += 1
 		if len(exp.Matches) > 0 {
 			numFound += 1
 		}
 	}
 
-	for _, exp := range gValidateState.TestData.ExpectedCorrelations {
+	for _, exp := range state.TestData.ExpectedCorrelations {
 		if exp.IsMet {
 			numFound += 1
 		}
 	}
 
-	prev := gValidateState.Coverage
-	gValidateState.Coverage = float64(numFound) / float64(numExpected)
+	prev := state.Coverage
+	if numExpected == 0 {
+		state.Coverage = 1.0 // only negated or optional events
+	} else {
+		state.Coverage = float64(numFound) / float64(numExpected)
+	}
 
-	if gVerbose && gValidateState.Coverage >= 1.0 && prev != gValidateState.Coverage {
+	if gVerbose && state.Coverage >= 1.0 && prev != state.Coverage {
 		fmt.Println("SUCCESS: Agent Telemetry Has Full Coverage")
 	}
 }
 
+/**
+ * UpdateDetections counts the _A_ rows of criteria that were detected.
+ * Detections are reported separately and do not affect coverage.
+ */
+func UpdateDetections(state *ExtractState) {
+	state.NumAlerts = uint64(len(state.TestData.ExpectedAlerts))
+	state.NumAlertsDetected = 0
+	state.AlertsMissing = nil
+	for _, alert := range state.TestData.ExpectedAlerts {
+		if len(alert.Matches) > 0 {
+			state.NumAlertsDetected += 1
+		} else {
+			state.AlertsMissing = append(state.AlertsMissing, alert.Id)
+		}
+	}
+}
+
+/**
+ * FindTechniqueTag returns the MITRE technique tag of evt that is the
+ * technique, or a sub-technique of it, e.g. T1003.001 for T1003.
+ * Returns empty string if none.
+ */
+func FindTechniqueTag(evt *types.SimpleEvent, technique string) string {
+	for _, tid := range evt.MitreTechniques {
+		if strings.HasPrefix(tid, technique) {
+			return tid
+		}
+	}
+	return ""
+}
+
+/**
+ * IsAlertMatch returns true if detection evt satisfies the alert row.
+ * Keywords are found case-insensitively in rule_name, rule_id or message.
+ * Field checks are on detection fields, and 'technique' which checks
+ * each MITRE tag of the event.  A row with neither matches detections
+ * tagged with the technique of the test.
+ */
+func IsAlertMatch(alert *types.AlertRow, evt *types.SimpleEvent, technique string) bool {
+	f := evt.DetectionFields
+	if f == nil {
+		return false
+	}
+	if len(alert.Keywords) == 0 && len(alert.FieldChecks) == 0 {
+		return len(FindTechniqueTag(evt, technique)) > 0
+	}
+
+	text := strings.ToLower(f.RuleName + "\n" + f.RuleId + "\n" + f.Message)
+	for _, keyword := range alert.Keywords {
+		if !strings.Contains(text, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+
+	fields := EventFieldValues(evt)
+	for _, fc := range alert.FieldChecks {
+		if fc.FieldName == "technique" {
+			isMatch := false
+			for _, tid := range evt.MitreTechniques {
+				if CheckFieldMatch(tid, &fc) {
+					isMatch = true
+					break
+				}
+			}
+			if !isMatch {
+				return false
+			}
+			continue
+		}
+		value, ok := fields[fc.FieldName]
+		if !ok {
+			fmt.Println("ERROR: unknown FieldName", fc)
+			return false
+		}
+		if !CheckFieldMatch(value, &fc) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * CheckAlerts matches detection evt against the _A_ rows of criteria.
+ * Returns true if any matched.
+ */
+func CheckAlerts(v *Validator, evt *types.SimpleEvent) bool {
+	retval := false
+	for _, alert := range v.State.TestData.ExpectedAlerts {
+		if IsAlertMatch(alert, evt, v.State.TestData.Technique) {
+			alert.Matches = append(alert.Matches, evt)
+			retval = true
+		}
+	}
+	if retval {
+		UpdateDetections(&v.State)
+	}
+	return retval
+}
+
+/**
+ * FindExpectedEvent returns the expected event with the given Id,
+ * or nil.  _C_ rows reference expected events by Id.
+ */
+func FindExpectedEvent(criteria *types.MitreTestCriteria, id string) *types.ExpectedEvent {
+	for _, exp := range criteria.ExpectedEvents {
+		if exp.Id == id {
+			return exp
+		}
+	}
+	return nil
+}
+
+/**
+ * IsChildProcess returns true if child's parent is the parent process.
+ * Uses unique pids when the telemetry provides them, otherwise pids.
+ */
+func IsChildProcess(parent *types.SimpleEvent, child *types.SimpleEvent) bool {
+	if parent.ProcessFields == nil || child.ProcessFields == nil {
+		return false
+	}
+	if len(parent.ProcessFields.UniquePid) > 0 && len(child.ProcessFields.ParentUniquePid) > 0 {
+		return parent.ProcessFields.UniquePid == child.ProcessFields.ParentUniquePid
+	}
+	return child.ProcessFields.ParentPid != 0 && child.ProcessFields.ParentPid == parent.ProcessFields.Pid
+}
+
+/**
+ * IsProcessChainMet returns true if a match for exps[0] has a child
+ * process matching exps[1], which has a child matching exps[2], etc.
+ */
+func IsProcessChainMet(parent *types.SimpleEvent, exps []*types.ExpectedEvent) bool {
+	if len(exps) == 0 {
+		return true
+	}
+	for _, evt := range exps[0].Matches {
+		if parent != nil && !IsChildProcess(parent, evt) {
+			continue
+		}
+		if IsProcessChainMet(evt, exps[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * IsProcessPipeMet returns true if every expected event has a matching
+ * process with the same ChainId (processes piped together).
+ */
+func IsProcessPipeMet(exps []*types.ExpectedEvent) bool {
+	for _, first := range exps[0].Matches {
+		if first.ProcessFields == nil || len(first.ProcessFields.ChainId) == 0 {
+			continue
+		}
+		chainId := first.ProcessFields.ChainId
+		numFound := 1
+		for _, exp := range exps[1:] {
+			for _, evt := range exp.Matches {
+				if evt.ProcessFields != nil && evt.ProcessFields.ChainId == chainId {
+					numFound += 1
+					break
+				}
+			}
+		}
+		if numFound == len(exps) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * IsSequenceMet returns true if there is a match for each of exps in
+ * time order, starting after prev if not nil.  With maxGapNs > 0,
+ * consecutive matches must be at most that far apart.  Matches without
+ * a timestamp cannot be ordered and are ignored.
+ */
+func IsSequenceMet(prev *types.SimpleEvent, exps []*types.ExpectedEvent, maxGapNs int64) bool {
+	if len(exps) == 0 {
+		return true
+	}
+	for _, evt := range exps[0].Matches {
+		if evt.Timestamp == 0 {
+			continue
+		}
+		if prev != nil {
+			if evt.Timestamp < prev.Timestamp || evt == prev {
+				continue
+			}
+			if maxGapNs > 0 && evt.Timestamp-prev.Timestamp > maxGapNs {
+				continue
+			}
+		}
+		if IsSequenceMet(evt, exps[1:], maxGapNs) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * EvaluateCorrelations sets IsMet for each _C_ row, using the matches of
+ * the expected events referenced by EventIndexes.  Must be called after
+ * all events have been processed.
+ *   _C_,Process,Pipe,0,1          : matched processes have the same ChainId
+ *   _C_,Process,Child,0,1         : match of 1 is a child process of match of 0
+ *   _C_,Sequence,Before,0,1,2     : matches of 0, 1 and 2 are in that time order
+ *   _C_,Sequence,After,0,1        : match of 0 is after match of 1
+ *   ...,maxgap=5s                 : consecutive matches of a Sequence at most 5s apart
+ */
+func EvaluateCorrelations(criteria *types.MitreTestCriteria) {
+	for _, corr := range criteria.ExpectedCorrelations {
+		corr.IsMet = false
+
+		exps := []*types.ExpectedEvent{}
+		for _, id := range corr.EventIndexes {
+			exp := FindExpectedEvent(criteria, strings.TrimSpace(id))
+			if exp == nil {
+				fmt.Println("ERROR: correlation references unknown expected event", id, corr)
+				break
+			}
+			exps = append(exps, exp)
+		}
+		if len(exps) != len(corr.EventIndexes) || len(exps) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(corr.Type) + "," + strings.ToUpper(corr.SubType) {
+		case "PROCESS,PIPE":
+			corr.IsMet = IsProcessPipeMet(exps)
+		case "PROCESS,CHILD":
+			corr.IsMet = IsProcessChainMet(nil, exps)
+		case "SEQUENCE,BEFORE":
+			corr.IsMet = IsSequenceMet(nil, exps, corr.MaxGapNs)
+		case "SEQUENCE,AFTER":
+			reversed := []*types.ExpectedEvent{}
+			for i := len(exps) - 1; i >= 0; i-- {
+				reversed = append(reversed, exps[i])
+			}
+			corr.IsMet = IsSequenceMet(nil, reversed, corr.MaxGapNs)
+		default:
+			fmt.Println("Unsupported correlation:", corr.Type, corr.SubType)
+		}
+
+		if gVerbose && corr.IsMet {
+			fmt.Println("Correlation met", corr.Type, corr.SubType, corr.EventIndexes)
+		}
+	}
+}
+
 func GetTelemChar(exp *types.ExpectedEvent) string {
 	switch strings.ToUpper(exp.EventType) {
 	case "PROCESS":
@@ -594,7 +1903,6 @@ func GetTelemChar(exp *types.ExpectedEvent) string {
 		}
 		return "F"
 	case "FILEMOD":
-		return "F"
 		if strings.ToUpper(exp.SubType) == "READ" {
 			return "f"
 		}
@@ -637,9 +1945,9 @@ func GetTelemChar(exp *types.ExpectedEvent) string {
  * this helps narrow down more so we don't have process events
  * from prereq, setup, cleanup stages of a test.
  *
- * Side-effects: will set testRun.TimeOfParentShell,ShellPid, TimeOfNextStage
+ * Side-effects: will set v.TimeOfParentShell, TimeOfNextStage
  */
-func IsGoArtStage(testRun *SingleTestRun, cmdline string, tsNs int64) bool {
+func IsGoArtStage(v *Validator, cmdline string, tsNs int64) bool {
 	a := []string{}
 	i := 1
 	if utils.GetPlatformName() == "windows" {
@@ -659,43 +1967,163 @@ func IsGoArtStage(testRun *SingleTestRun, cmdline string, tsNs int64) bool {
 	if gVerbose {
 		fmt.Println("Found stage", stageName, "for", technique, "folder:", folder)
 	}
+	isSameTest := IsTestWorkingDir(v.testRun, technique, folder)
 	if "test" == stageName {
 		// is this the target test?
-		if technique == testRun.criteria.Technique {
-			tsttok := fmt.Sprintf("%s_%d", technique, testRun.criteria.TestIndex)
-			if gVerbose {
-				fmt.Println("contains check", folder, tsttok, tsNs)
-			}
-			if strings.Contains(folder, tsttok) {
-				testRun.TimeOfParentShell = tsNs
-				testRun.TimeOfNextStage = 0
-			}
+		if isSameTest {
+			v.TimeOfParentShell = tsNs
+			v.TimeOfNextStage = 0
+		}
+	} else if 0 != v.TimeOfParentShell && 0 == v.TimeOfNextStage {
+		// When tests run in parallel, stages of other tests can start
+		// while this test is running, so only those after the end of test
+		// stage are considered.
+		if isSameTest || 0 == v.testRun.EndTime || tsNs > v.testRun.EndTime {
+			v.TimeOfNextStage = tsNs
 		}
-	} else if 0 != testRun.TimeOfParentShell {
-		testRun.TimeOfNextStage = tsNs
 	}
 	return true
 }
 
+/**
+ * IsTestWorkingDir returns true if the goartrun folder name from a
+ * stage cmdline belongs to testRun.
+ */
+func IsTestWorkingDir(testRun *SingleTestRun, technique string, folder string) bool {
+	if len(testRun.workingDir) > 0 {
+		return filepath.Base(testRun.workingDir) == folder
+	}
+	if technique != testRun.criteria.Technique {
+		return false
+	}
+	tsttok := fmt.Sprintf("%s_%d", technique, testRun.criteria.TestIndex)
+	if gVerbose {
+		fmt.Println("contains check", folder, tsttok)
+	}
+	return strings.Contains(folder, tsttok+"-")
+}
+
+// ProcessTree holds the goartrun test shell and all of its descendants
+type ProcessTree struct {
+	Pids       map[int64]bool
+	UniquePids map[string]bool
+}
+
+func NewProcessTree() *ProcessTree {
+	return &ProcessTree{Pids: map[int64]bool{}, UniquePids: map[string]bool{}}
+}
+
+func (t *ProcessTree) Add(pid int64, uniquePid string) {
+	if pid != 0 {
+		t.Pids[pid] = true
+	}
+	if len(uniquePid) > 0 {
+		t.UniquePids[uniquePid] = true
+	}
+}
+
+/**
+ * Contains returns true if the process is in the tree.  Unique pids are
+ * used when available, since pids can be reused.
+ */
+func (t *ProcessTree) Contains(pid int64, uniquePid string) bool {
+	if t == nil {
+		return false
+	}
+	if len(uniquePid) > 0 && len(t.UniquePids) > 0 {
+		return t.UniquePids[uniquePid]
+	}
+	return pid != 0 && t.Pids[pid]
+}
+
+/**
+ * UpdateProcessTree adds the process to tree if its parent is in the tree.
+ * Assumes process events are in time order, parents before children.
+ */
+func UpdateProcessTree(tree *ProcessTree, fields *types.SimpleProcessFields) {
+	if tree == nil {
+		return
+	}
+	if tree.Contains(fields.ParentPid, fields.ParentUniquePid) {
+		tree.Add(fields.Pid, fields.UniquePid)
+	}
+}
+
+/**
+ * GetEventPid returns the pid and unique pid (if any) of the process
+ * responsible for the event.
+ */
+func GetEventPid(evt *types.SimpleEvent) (int64, string) {
+	switch {
+	case evt.ProcessFields != nil:
+		return evt.ProcessFields.Pid, evt.ProcessFields.UniquePid
+	case evt.ProcessExitFields != nil:
+		return evt.ProcessExitFields.Pid, evt.ProcessExitFields.UniquePid
+	case evt.FileFields != nil:
+		return evt.FileFields.Pid, evt.FileFields.UniquePid
+	case evt.NetflowFields != nil:
+		return evt.NetflowFields.Pid, evt.NetflowFields.UniquePid
+	case evt.ETWFields != nil:
+		return evt.ETWFields.Pid, ""
+	case evt.AMSIFields != nil:
+		return evt.AMSIFields.Pid, ""
+	case evt.RegFields != nil:
+		return evt.RegFields.Pid, ""
+	case evt.APIFields != nil:
+		return evt.APIFields.Pid, evt.APIFields.UniquePid
+	case evt.AuthFields != nil:
+		return evt.AuthFields.Pid, evt.AuthFields.UniquePid
+	case evt.ModuleFields != nil:
+		return evt.ModuleFields.Pid, evt.ModuleFields.UniquePid
+	case evt.VolumeFields != nil:
+		return evt.VolumeFields.Pid, evt.VolumeFields.UniquePid
+	case evt.PTraceFields != nil:
+		return evt.PTraceFields.Pid, evt.PTraceFields.UniquePid
+	case evt.NetsniffFields != nil:
+		return evt.NetsniffFields.Pid, evt.NetsniffFields.UniquePid
+	case evt.DetectionFields != nil:
+		return evt.DetectionFields.Pid, evt.DetectionFields.UniquePid
+	}
+	return 0, ""
+}
+
+/**
+ * IsEventInProcessTree returns true if the event was generated by the
+ * test shell or one of its descendant processes.  Events without a pid
+ * can't be attributed, and return false.
+ */
+func IsEventInProcessTree(v *Validator, evt *types.SimpleEvent) bool {
+	pid, uniquePid := GetEventPid(evt)
+	return v.processTree.Contains(pid, uniquePid)
+}
+
+func UseTimeAttribution() bool {
+	return flagAttribution != "tree"
+}
+
+func UseTreeAttribution() bool {
+	return flagAttribution == "tree" || flagAttribution == "both"
+}
+
 /**
  * IsGoArtWorkDirEvent will check the file event target path,
  * if it matches create or delete, then it's the start/end of test
  *
- * Side-effects: will set testRun.TimeWorkDirCreate, TimeWorkDirDelete
+ * Side-effects: will set v.TimeWorkDirCreate, TimeWorkDirDelete
  */
-func IsGoArtWorkDirEvent(testRun *SingleTestRun, evt *types.SimpleEvent) bool {
-	workingDirToCompare := testRun.workingDir
+func IsGoArtWorkDirEvent(v *Validator, evt *types.SimpleEvent) bool {
+	workingDirToCompare := v.testRun.workingDir
 	if runtime.GOOS == "windows" {
 		// This is required since workDir starts with C:\, filemod path starts with /device/harddisk<n>
 		workingDirToCompare = strings.Replace(workingDirToCompare, "C:", "", 1)
 	}
 	if strings.HasSuffix(evt.FileFields.TargetPath, workingDirToCompare) {
 		if evt.FileFields.Action == types.SimpleFileActionDelete {
-			testRun.TimeWorkDirDelete = evt.Timestamp
+			v.TimeWorkDirDelete = evt.Timestamp
 		} else if evt.FileFields.Action == types.SimpleFileActionOpenRead {
 			return false
 		} else {
-			testRun.TimeWorkDirCreate = evt.Timestamp
+			v.TimeWorkDirCreate = evt.Timestamp
 		}
 		return true
 	}
@@ -707,12 +2135,24 @@ func IsGoArtWorkDirEvent(testRun *SingleTestRun, evt *types.SimpleEvent) bool {
  * event types found/not-found as a string.
  * e.g. "P<f>F<N>" would represent Process and FileMod
  *      found, but file-read and netflow not found
+ * Negated (_N_) events are prefixed with '!', "!F" when not
+ * seen, and "<!F>" when seen.
+ * Optional (_?_) events not found are wrapped in square
+ * brackets, e.g. "[N]", and do not affect status.
  */
 func GetTelemTypes(criteria *types.MitreTestCriteria) string {
 	s := ""
 	for _, exp := range criteria.ExpectedEvents {
 		c := GetTelemChar(exp)
-		if len(exp.Matches) == 0 {
+		if exp.IsNegated {
+			if len(exp.Matches) == 0 {
+				s += "!" + c
+			} else {
+				s += "<!" + c + ">"
+			}
+		} else if exp.IsMaybe && len(exp.Matches) == 0 {
+			s += "[" + c + "]"
+		} else if len(exp.Matches) == 0 {
 			s += "<" + c + ">"
 		} else {
 			s += c
@@ -739,27 +2179,100 @@ func BoolAsString(val bool) string {
 	return "false"
 }
 
-// TODO: avoid mem copy of string array
-func ReadFileLines(path string) ([]string, error) {
-	ret := []string{}
+/**
+ * TelemetryReader reads the simple_telemetry and telemetry (raw) files
+ * of a tool in lockstep, one event per line.  Lines can be any length.
+ * If the raw file is missing or has fewer lines, the simple event line
+ * is used in its place.
+ */
+type TelemetryReader struct {
+	simpleFile *os.File
+	rawFile    *os.File
+	simple     *bufio.Reader
+	raw        *bufio.Reader
+
+	NumLines      uint64
+	NumMismatched uint64
+}
+
+func OpenTelemetryReader(dir string, suffix string) (*TelemetryReader, error) {
+	reader := &TelemetryReader{}
+	var err error
 
-	file, err := os.Open(path)
+	path := filepath.FromSlash(dir + "/simple_telemetry" + suffix + ".json")
+	reader.simpleFile, err = os.Open(path)
 	if err != nil {
-		return ret, err
+		return nil, err
 	}
-	defer file.Close()
+	reader.simple = bufio.NewReaderSize(reader.simpleFile, 64*1024)
 
-	scanner := bufio.NewScanner(file)
+	path = filepath.FromSlash(dir + "/telemetry" + suffix + ".json")
+	reader.rawFile, err = os.Open(path)
+	if err != nil {
+		fmt.Println("WARN: unable to open raw telemetry, matches will contain simple events", err)
+	} else {
+		reader.raw = bufio.NewReaderSize(reader.rawFile, 64*1024)
+	}
+	return reader, nil
+}
 
-	//adjust the capacity to your need (max characters in line)
-	const maxCapacity = 1024 * 1024 // 1 MB or 1,048,576 characters
-	buf := make([]byte, maxCapacity)
-	scanner.Buffer(buf, maxCapacity)
+/**
+ * Next returns the next non-empty simple event line and its raw line.
+ * Returns io.EOF when there are no more simple events.
+ */
+func (r *TelemetryReader) Next() ([]byte, []byte, error) {
+	for {
+		line, err := ReadLine(r.simple)
+		if err != nil {
+			if err == io.EOF && r.raw != nil {
+				// any remaining raw lines have no simple event
+				for {
+					_, err2 := ReadLine(r.raw)
+					if err2 != nil {
+						break
+					}
+					r.NumMismatched += 1
+				}
+			}
+			return nil, nil, err
+		}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		ret = append(ret, line)
+		var rawLine []byte
+		if r.raw != nil {
+			rawLine, err = ReadLine(r.raw)
+			if err != nil {
+				r.raw = nil
+			}
+		}
+		if len(line) == 0 {
+			continue
+		}
+		r.NumLines += 1
+		if rawLine == nil {
+			if r.rawFile != nil {
+				r.NumMismatched += 1
+			}
+			rawLine = line
+		}
+		return line, rawLine, nil
 	}
+}
 
-	return ret, scanner.Err()
+func (r *TelemetryReader) Close() {
+	r.simpleFile.Close()
+	if r.rawFile != nil {
+		r.rawFile.Close()
+	}
+}
+
+/**
+ * ReadLine returns the next line without line ending.  Unlike
+ * bufio.Scanner, there is no maximum line length.
+ */
+func ReadLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil && !(err == io.EOF && len(line) > 0) {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
 }
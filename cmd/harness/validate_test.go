@@ -1,7 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -47,3 +56,701 @@ func TestFindGoArtStageRegex(t *testing.T) {
 	assert.Equal(t, "T1027.002", technique)
 	assert.Equal(t, "test", stageName)
 }
+
+func procEvent(pid, ppid int64, chainId string) *types.SimpleEvent {
+	evt := &types.SimpleEvent{EventType: types.SimpleSchemaProcess}
+	evt.ProcessFields = &types.SimpleProcessFields{Pid: pid, ParentPid: ppid, ChainId: chainId}
+	return evt
+}
+
+func TestEvaluateCorrelations(t *testing.T) {
+	criteria := &types.MitreTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process", Matches: []*types.SimpleEvent{procEvent(100, 1, "abc")}},
+		{Id: "1", EventType: "Process", Matches: []*types.SimpleEvent{procEvent(101, 1, "abc")}},
+		{Id: "2", EventType: "Process", Matches: []*types.SimpleEvent{procEvent(102, 100, "")}},
+	}
+	criteria.ExpectedCorrelations = []*types.CorrelationRow{
+		{Id: "0", Type: "Process", SubType: "Pipe", EventIndexes: []string{"0", "1"}},
+		{Id: "1", Type: "Process", SubType: "Child", EventIndexes: []string{"0", "2"}},
+		{Id: "2", Type: "Process", SubType: "Child", EventIndexes: []string{"1", "2"}},
+		{Id: "3", Type: "Process", SubType: "Pipe", EventIndexes: []string{"0", "2"}},
+		{Id: "4", Type: "Process", SubType: "Child", EventIndexes: []string{"0", "9"}},
+	}
+
+	EvaluateCorrelations(criteria)
+
+	assert.True(t, criteria.ExpectedCorrelations[0].IsMet)
+	assert.True(t, criteria.ExpectedCorrelations[1].IsMet)
+	assert.False(t, criteria.ExpectedCorrelations[2].IsMet)
+	assert.False(t, criteria.ExpectedCorrelations[3].IsMet)
+	assert.False(t, criteria.ExpectedCorrelations[4].IsMet)
+	assert.Equal(t, "PPPCC<C><C><C>", GetTelemTypes(criteria))
+
+	// unique pids take precedence over pids
+	criteria.ExpectedEvents[0].Matches[0].ProcessFields.UniquePid = "u100"
+	criteria.ExpectedEvents[2].Matches[0].ProcessFields.ParentUniquePid = "u999"
+	EvaluateCorrelations(criteria)
+	assert.False(t, criteria.ExpectedCorrelations[1].IsMet)
+}
+
+func TestIsGoArtStageParallel(t *testing.T) {
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = "T1560.002"
+	criteria.TestIndex = 3
+	testRun := &SingleTestRun{criteria: criteria, workingDir: "/tmp/artwork-T1560.002_3-458617291", EndTime: 2000}
+	v := NewValidator(testRun, &TelemTool{})
+
+	assert.True(t, IsGoArtStage(v, "sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-test.bash", 1000))
+	assert.Equal(t, int64(1000), v.TimeOfParentShell)
+
+	// test stage of another test running concurrently does not end window
+	assert.True(t, IsGoArtStage(v, "sh /tmp/artwork-T1560.002_3-111111111/goart-T1560.002-test.bash", 1500))
+	assert.Equal(t, int64(1000), v.TimeOfParentShell)
+	assert.Equal(t, int64(0), v.TimeOfNextStage)
+
+	assert.True(t, IsGoArtStage(v, "sh /tmp/artwork-T1001_1-222222222/goart-T1001-cleanup.sh", 1600))
+	assert.Equal(t, int64(0), v.TimeOfNextStage)
+
+	assert.True(t, IsGoArtStage(v, "sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-cleanup.bash", 2100))
+	assert.Equal(t, int64(2100), v.TimeOfNextStage)
+}
+
+func TestProcessTreeAttribution(t *testing.T) {
+	prev := flagAttribution
+	flagAttribution = "tree"
+	defer func() { flagAttribution = prev }()
+
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = "T1560.002"
+	criteria.TestIndex = 3
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "~=", Value: "zip"}}},
+		{Id: "1", EventType: "File", SubType: "WRITE", FieldChecks: []types.FieldCriteria{{FieldName: "path", Op: "=", Value: "/tmp/x.zip"}}},
+	}
+	testRun := &SingleTestRun{criteria: criteria, workingDir: "/tmp/artwork-T1560.002_3-458617291"}
+	v := NewValidator(testRun, &TelemTool{})
+
+	shell := procEvent(100, 1, "")
+	shell.ProcessFields.Cmdline = "sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-test.bash"
+	shell.Timestamp = 1000
+	assert.False(t, CheckProcessEvent(v, shell, ""))
+	assert.Equal(t, int64(100), v.ShellPid)
+
+	noise := procEvent(200, 1, "")
+	noise.ProcessFields.Cmdline = "zip -r /tmp/other.zip"
+	assert.False(t, CheckProcessEvent(v, noise, ""))
+
+	child := procEvent(101, 100, "")
+	child.ProcessFields.Cmdline = "zip -r /tmp/x.zip"
+	assert.True(t, CheckProcessEvent(v, child, ""))
+	assert.Equal(t, 1, len(v.State.TestData.ExpectedEvents[0].Matches))
+	assert.Equal(t, 0, len(criteria.ExpectedEvents[0].Matches)) // criteria is not modified
+
+	write := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod}
+	write.FileFields = &types.SimpleFileFields{Action: types.SimpleFileActionCreate, TargetPath: "/tmp/x.zip", Pid: 101}
+	assert.True(t, IsEventInProcessTree(v, write))
+
+	write.FileFields.Pid = 200
+	assert.False(t, IsEventInProcessTree(v, write))
+
+	write.FileFields.Pid = 0
+	assert.False(t, IsEventInProcessTree(v, write))
+}
+
+func TestProcessTreeOutOfOrder(t *testing.T) {
+	prev := flagAttribution
+	flagAttribution = "tree"
+	defer func() { flagAttribution = prev }()
+
+	dir := t.TempDir()
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = "T1560.002"
+	criteria.TestIndex = 3
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "~=", Value: "zip"}}},
+		{Id: "1", EventType: "File", SubType: "WRITE", FieldChecks: []types.FieldCriteria{{FieldName: "path", Op: "=", Value: "/tmp/x.zip"}}},
+		{Id: "2", EventType: "File", SubType: "WRITE", FieldChecks: []types.FieldCriteria{{FieldName: "path", Op: "=", Value: "/tmp/other.zip"}}},
+	}
+	testRun := &SingleTestRun{criteria: criteria, workingDir: "/tmp/artwork-T1560.002_3-458617291", resultsDir: dir}
+
+	shell := procEvent(100, 1, "")
+	shell.ProcessFields.Cmdline = "sh /tmp/artwork-T1560.002_3-458617291/goart-T1560.002-test.bash"
+	shell.Timestamp = 1000
+	write := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: 1300}
+	write.FileFields = &types.SimpleFileFields{Action: types.SimpleFileActionCreate, TargetPath: "/tmp/x.zip", Pid: 102}
+	zip := procEvent(102, 101, "")
+	zip.ProcessFields.Cmdline = "zip -r /tmp/x.zip"
+	zip.Timestamp = 1200
+	sh := procEvent(101, 100, "")
+	sh.ProcessFields.Cmdline = "sh -c ./archive.sh"
+	sh.Timestamp = 1100
+	noise := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: 1300}
+	noise.FileFields = &types.SimpleFileFields{Action: types.SimpleFileActionCreate, TargetPath: "/tmp/other.zip", Pid: 200}
+
+	// children and their events before their parents
+	writeTelemetryFiles(t, dir, "", []*types.SimpleEvent{shell, write, zip, noise, sh}, nil)
+	ValidateTestRuns([]*SingleTestRun{testRun}, []*TelemTool{{}}, dir)
+
+	v := testRun.validators[0]
+	assert.Equal(t, 1, len(v.State.TestData.ExpectedEvents[0].Matches))
+	assert.Equal(t, 1, len(v.State.TestData.ExpectedEvents[1].Matches))
+	assert.Equal(t, 0, len(v.State.TestData.ExpectedEvents[2].Matches))
+	assert.True(t, v.processTree.Contains(102, ""))
+	assert.Equal(t, 0, len(v.heldEvents))
+}
+
+func TestNegatedEvents(t *testing.T) {
+	state := &ExtractState{}
+	state.TestData.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process"},
+		{Id: "1", EventType: "File", SubType: "READ", IsNegated: true},
+	}
+	UpdateCoverage(state)
+	assert.Equal(t, 0.0, state.Coverage)
+	assert.Equal(t, "<P>!f", GetTelemTypes(&state.TestData))
+
+	state.TestData.ExpectedEvents[0].Matches = []*types.SimpleEvent{procEvent(100, 1, "")}
+	UpdateCoverage(state)
+	assert.Equal(t, 1.0, state.Coverage)
+	assert.Equal(t, uint64(0), state.NumUnexpected)
+
+	state.TestData.ExpectedEvents[1].Matches = []*types.SimpleEvent{{EventType: types.SimpleSchemaFileRead}}
+	UpdateCoverage(state)
+	assert.Equal(t, 1.0, state.Coverage)
+	assert.Equal(t, uint64(1), state.NumUnexpected)
+	assert.Equal(t, "P<!f>", GetTelemTypes(&state.TestData))
+
+	// only negated events
+	state.TestData.ExpectedEvents = state.TestData.ExpectedEvents[1:]
+	UpdateCoverage(state)
+	assert.Equal(t, 1.0, state.Coverage)
+}
+
+func TestOptionalEvents(t *testing.T) {
+	state := &ExtractState{}
+	state.TestData.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process", Matches: []*types.SimpleEvent{procEvent(100, 1, "")}},
+		{Id: "1", EventType: "Netflow", IsMaybe: true},
+		{Id: "2", EventType: "Process", IsMaybe: true, Matches: []*types.SimpleEvent{procEvent(101, 1, "")}},
+	}
+	UpdateCoverage(state)
+	assert.Equal(t, 1.0, state.Coverage)
+	assert.Equal(t, uint64(2), state.NumOptional)
+	assert.Equal(t, uint64(1), state.NumOptionalMatched)
+	assert.Equal(t, []string{"1"}, state.OptionalMissing)
+	assert.Equal(t, "P[N]P", GetTelemTypes(&state.TestData))
+}
+
+func writeTelemetryFiles(t *testing.T, dir string, suffix string, events []*types.SimpleEvent, rawLines []string) {
+	simple := ""
+	raw := ""
+	for i, evt := range events {
+		j, err := json.Marshal(evt)
+		assert.Nil(t, err)
+		simple += string(j) + "\n"
+		if rawLines != nil {
+			raw += rawLines[i] + "\n"
+		}
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "simple_telemetry"+suffix+".json"), []byte(simple), 0644))
+	if rawLines != nil {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "telemetry"+suffix+".json"), []byte(raw), 0644))
+	}
+}
+
+func TestValidateSimpleTelemetryStreaming(t *testing.T) {
+	dir := t.TempDir()
+	base := int64(1700000000) * int64(time.Second)
+
+	criteria1 := &types.AtomicTestCriteria{}
+	criteria1.Technique = "T1000"
+	criteria1.TestIndex = 1
+	criteria1.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "~=", Value: "whoami"}}},
+	}
+	criteria2 := &types.AtomicTestCriteria{}
+	criteria2.Technique = "T1001"
+	criteria2.TestIndex = 1
+	criteria2.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "File", SubType: "WRITE", FieldChecks: []types.FieldCriteria{{FieldName: "path", Op: "=", Value: "/tmp/b.txt"}}},
+		{Id: "1", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "~=", Value: "whoami"}}},
+	}
+
+	testRun1 := &SingleTestRun{criteria: criteria1, workingDir: "/tmp/artwork-T1000_1-111", resultsDir: filepath.Join(dir, "T1000_1")}
+	testRun1.StartTime = base
+	testRun1.EndTime = base + int64(time.Second)
+	testRun2 := &SingleTestRun{criteria: criteria2, workingDir: "/tmp/artwork-T1001_1-222", resultsDir: filepath.Join(dir, "T1001_1")}
+	testRun2.StartTime = base + 100*int64(time.Second)
+	testRun2.EndTime = base + 101*int64(time.Second)
+	os.Mkdir(testRun1.resultsDir, 0755)
+	os.Mkdir(testRun2.resultsDir, 0755)
+
+	shell1 := procEvent(10, 1, "")
+	shell1.ProcessFields.Cmdline = "sh /tmp/artwork-T1000_1-111/goart-T1000-test.sh"
+	shell1.Timestamp = base
+	whoami := procEvent(11, 10, "")
+	whoami.ProcessFields.Cmdline = "whoami"
+	whoami.Timestamp = base + 1000
+	cleanup1 := procEvent(12, 1, "")
+	cleanup1.ProcessFields.Cmdline = "sh /tmp/artwork-T1000_1-111/goart-T1000-cleanup.sh"
+	cleanup1.Timestamp = base + int64(2*time.Second)
+
+	mkdir2 := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: base + 90*int64(time.Second)}
+	mkdir2.FileFields = &types.SimpleFileFields{Action: types.SimpleFileActionCreate, TargetPath: "/tmp/artwork-T1001_1-222"}
+	shell2 := procEvent(20, 1, "")
+	shell2.ProcessFields.Cmdline = "sh /tmp/artwork-T1001_1-222/goart-T1001-test.sh"
+	shell2.Timestamp = testRun2.StartTime
+	write := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: testRun2.StartTime + 1000}
+	write.FileFields = &types.SimpleFileFields{Action: types.SimpleFileActionOpenWrite, TargetPath: "/tmp/b.txt", Pid: 21}
+
+	events := []*types.SimpleEvent{shell1, whoami, cleanup1, mkdir2, shell2, write}
+	rawLines := []string{"{}", "{\"big\":\"" + strings.Repeat("x", 2*1024*1024) + "\"}", "{}", "{}", "{}", "{\"write\":1}"}
+	writeTelemetryFiles(t, dir, "", events, rawLines)
+
+	ValidateTestRuns([]*SingleTestRun{testRun1, testRun2}, []*TelemTool{{}}, dir)
+
+	assert.Equal(t, types.StatusValidateSuccess, testRun1.status)
+	assert.Equal(t, uint64(4), testRun1.validators[0].State.TotalEvents) // only process events
+	assert.Equal(t, types.StatusValidatePartial, testRun2.status)
+	assert.Equal(t, uint64(6), testRun2.validators[0].State.TotalEvents)
+	assert.Equal(t, "F<P>", readMatchString(t, testRun2))
+
+	data, err := os.ReadFile(filepath.Join(testRun1.resultsDir, "matches.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(rawLines[1])+1, len(data))
+
+	// missing raw telemetry uses simple events
+	os.Remove(filepath.Join(dir, "telemetry.json"))
+	ValidateTestRuns([]*SingleTestRun{testRun2}, []*TelemTool{{}}, dir)
+	data, err = os.ReadFile(filepath.Join(testRun2.resultsDir, "matches.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "/tmp/b.txt")
+}
+
+func TestValidateTestRunsConcurrent(t *testing.T) {
+	prevWorkers, prevFilter := flagValidateWorkers, flagFilterByGoartrunShell
+	flagValidateWorkers = 4
+	flagFilterByGoartrunShell = false
+	defer func() { flagValidateWorkers, flagFilterByGoartrunShell = prevWorkers, prevFilter }()
+
+	dir := t.TempDir()
+	testRuns := []*SingleTestRun{}
+	events := []*types.SimpleEvent{}
+	for i := 0; i < 3; i++ {
+		criteria := &types.AtomicTestCriteria{}
+		criteria.Technique = fmt.Sprintf("T100%d", i)
+		criteria.TestIndex = 1
+		criteria.ExpectedEvents = []*types.ExpectedEvent{
+			{Id: "0", EventType: "Process", FieldChecks: []types.FieldCriteria{{FieldName: "cmdline", Op: "=", Value: fmt.Sprintf("cmd%d", i)}}},
+		}
+		testRun := &SingleTestRun{criteria: criteria, resultsDir: filepath.Join(dir, criteria.Technique)}
+		os.Mkdir(testRun.resultsDir, 0755)
+		testRuns = append(testRuns, testRun)
+
+		evt := procEvent(int64(100+i), 1, "")
+		evt.ProcessFields.Cmdline = fmt.Sprintf("cmd%d", i)
+		events = append(events, evt)
+	}
+
+	// second tool only sees the process of the first test
+	writeTelemetryFiles(t, dir, "_a", events, nil)
+	writeTelemetryFiles(t, dir, "_b", events[:1], nil)
+
+	tools := []*TelemTool{{Suffix: "_a"}, {Suffix: "_b"}}
+	ValidateTestRuns(testRuns, tools, dir)
+
+	for i, testRun := range testRuns {
+		assert.Equal(t, 2, len(testRun.validators))
+		assert.Equal(t, types.StatusValidateSuccess, testRun.validators[0].Status)
+		assert.Equal(t, 1, len(testRun.validators[0].State.TestData.ExpectedEvents[0].Matches))
+		assert.Equal(t, 0, len(testRun.criteria.ExpectedEvents[0].Matches))
+		if i == 0 {
+			assert.Equal(t, types.StatusValidateSuccess, testRun.validators[1].Status)
+		} else {
+			assert.Equal(t, types.StatusValidateFail, testRun.validators[1].Status)
+			assert.Equal(t, 0, len(testRun.validators[1].State.TestData.ExpectedEvents[0].Matches))
+			assert.Equal(t, types.StatusValidateFail, testRun.status) // --combine all
+		}
+	}
+}
+
+func TestCombineToolStatus(t *testing.T) {
+	statuses := []types.TestStatus{types.StatusValidatePartial, types.StatusValidateSuccess, types.StatusValidateFail}
+	assert.Equal(t, types.StatusValidateSuccess, CombineToolStatus(statuses, "any"))
+	assert.Equal(t, types.StatusValidateFail, CombineToolStatus(statuses, "all"))
+	assert.Equal(t, types.StatusValidateFail, CombineToolStatus(statuses, "columns"))
+
+	statuses = append(statuses, types.StatusValidateUnexpected)
+	assert.Equal(t, types.StatusValidateUnexpected, CombineToolStatus(statuses, "all"))
+	assert.Equal(t, types.StatusValidateSuccess, CombineToolStatus(statuses, "any"))
+
+	assert.Equal(t, types.StatusValidatePartial, CombineToolStatus(statuses[:1], "all"))
+	assert.Equal(t, types.StatusUnknown, CombineToolStatus(nil, "any"))
+}
+
+func readMatchString(t *testing.T, testRun *SingleTestRun) string {
+	data, err := os.ReadFile(filepath.Join(testRun.resultsDir, "match_string.txt"))
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestStructuredEventCheckers(t *testing.T) {
+	criteria := &types.AtomicTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "AUTH", FieldChecks: []types.FieldCriteria{{FieldName: "username", Op: "=", Value: "root"}, {FieldName: "is_success", Op: "=", Value: "false"}}},
+		{Id: "1", EventType: "MODULE", SubType: "LOAD", FieldChecks: []types.FieldCriteria{{FieldName: "name", Op: "=", Value: "evil"}}},
+		{Id: "2", EventType: "VOLUME", FieldChecks: []types.FieldCriteria{{FieldName: "mount_path", Op: "*=", Value: "/mnt"}}},
+		{Id: "3", EventType: "PTRACE", FieldChecks: []types.FieldCriteria{{FieldName: "target_pid", Op: "=", Value: "42"}}},
+		{Id: "4", EventType: "NETSNIFF", SubType: "*", FieldChecks: []types.FieldCriteria{{FieldName: "interface", Op: "=", Value: "eth0"}}},
+		{Id: "5", EventType: "ALERT", SubType: "cred*", FieldChecks: []types.FieldCriteria{{FieldName: "severity", Op: "=", Value: "high"}}},
+	}
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+
+	events := []*types.SimpleEvent{
+		{EventType: types.SimpleSchemaAuth, AuthFields: &types.SimpleAuthFields{Username: "root", IsSuccess: false}},
+		{EventType: types.SimpleSchemaModule, ModuleFields: &types.SimpleModuleFields{Action: "UNLOAD", Name: "evil"}},
+		{EventType: types.SimpleSchemaModule, ModuleFields: &types.SimpleModuleFields{Action: "LOAD", Name: "evil"}},
+		{EventType: types.SimpleSchemaVolume, VolumeFields: &types.SimpleVolumeFields{Action: "MOUNT", MountPath: "/mnt/x"}},
+		{EventType: types.SimpleSchemaPTrace, PTraceFields: &types.SimplePTraceFields{Request: "ATTACH", TargetPid: 42}},
+		{EventType: types.SimpleSchemaNetsniff, NetsniffFields: &types.SimpleNetsniffFields{Action: "PROMISC", Interface: "eth0"}},
+		{EventType: types.SimpleSchemaDetection, DetectionFields: &types.SimpleDetectionFields{RuleName: "Credential Dumping", Severity: "high"}},
+	}
+	expected := []bool{true, false, true, true, true, true, true}
+	for i, evt := range events {
+		checker := GetEventChecker(evt.EventType)
+		assert.NotNil(t, checker, evt.EventType)
+		assert.Equal(t, expected[i], checker(v, evt, ""), evt.EventType)
+	}
+	for _, exp := range v.State.TestData.ExpectedEvents {
+		assert.Equal(t, 1, len(exp.Matches), exp.EventType)
+	}
+
+	// missing fields struct
+	assert.False(t, CheckAuthEvent(v, &types.SimpleEvent{EventType: types.SimpleSchemaAuth}, ""))
+}
+
+func TestMatchesSubType(t *testing.T) {
+	exp, err := utils.EventFromRow(0, []string{"_E_", "Alert", "cred.dump*", "severity=high"})
+	assert.Nil(t, err)
+	assert.NotNil(t, exp.SubTypeRegex)
+	assert.True(t, MatchesSubType(&exp, "Cred.Dump Detected"))
+	assert.False(t, MatchesSubType(&exp, "credXdump"))
+
+	assert.True(t, MatchesSubType(&types.ExpectedEvent{SubType: "LOAD"}, "load"))
+	assert.True(t, MatchesSubType(&types.ExpectedEvent{}, "anything"))
+	assert.True(t, MatchesSubType(&types.ExpectedEvent{SubType: "c*(x"}, "cred(x"))
+}
+
+func TestAlertRows(t *testing.T) {
+	criteria := &types.AtomicTestCriteria{}
+	criteria.Technique = "T1003"
+	rows := [][]string{
+		{"_A_", "Process", "credential", "severity=high"},
+		{"_A_", "Technique"},
+		{"_A_", "Process", "technique~=T1059"},
+	}
+	for i, row := range rows {
+		alert, err := utils.AlertFromRow(i, row)
+		assert.Nil(t, err)
+		criteria.ExpectedAlerts = append(criteria.ExpectedAlerts, &alert)
+	}
+	assert.Equal(t, []string{"credential"}, criteria.ExpectedAlerts[0].Keywords)
+	assert.Equal(t, []types.FieldCriteria{{FieldName: "severity", Op: "=", Value: "high"}}, criteria.ExpectedAlerts[0].FieldChecks)
+
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+	assert.Equal(t, uint64(3), v.State.NumAlerts)
+
+	evt := &types.SimpleEvent{EventType: types.SimpleSchemaDetection, DetectionFields: &types.SimpleDetectionFields{RuleName: "Credential Dumping", Severity: "low"}}
+	assert.False(t, CheckAlerts(v, evt))
+
+	evt.DetectionFields.Severity = "high"
+	assert.True(t, CheckAlerts(v, evt))
+	assert.Equal(t, uint64(1), v.State.NumAlertsDetected)
+
+	evt = &types.SimpleEvent{EventType: types.SimpleSchemaDetection, DetectionFields: &types.SimpleDetectionFields{RuleName: "Other"}, MitreTechniques: []string{"T1003.001"}}
+	assert.True(t, CheckAlerts(v, evt))
+	assert.Equal(t, uint64(2), v.State.NumAlertsDetected)
+	assert.Equal(t, []string{"2"}, v.State.AlertsMissing)
+
+	// detections do not affect coverage or match string
+	assert.Equal(t, "", v.MatchString())
+	assert.Equal(t, 0, len(criteria.ExpectedAlerts[0].Matches))
+}
+
+func TestParseFlowStr(t *testing.T) {
+	flow, err := ParseFlowStr("TCP:10.0.0.5:41514->10.0.0.1:22", "tcp:10.0.0.5:41514->victim-host:22")
+	assert.Nil(t, err)
+	assert.Equal(t, FlowFields{Proto: "tcp", SrcIp: "10.0.0.5", SrcPort: "41514", DstIp: "10.0.0.1", DstPort: "22", DstHost: "victim-host"}, *flow)
+
+	flow, err = ParseFlowStr("udp:[fe80::1]:53->::1:5353", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "fe80::1", flow.SrcIp)
+	assert.Equal(t, "::1", flow.DstIp)
+	assert.Equal(t, "5353", flow.DstPort)
+
+	_, err = ParseFlowStr("garbage", "")
+	assert.NotNil(t, err)
+}
+
+func TestNetflowFieldChecks(t *testing.T) {
+	exp := func(row ...string) *types.ExpectedEvent {
+		evt, err := utils.EventFromRow(0, append([]string{"_E_", "NETFLOW"}, row...))
+		assert.Nil(t, err)
+		return &evt
+	}
+	criteria := &types.AtomicTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		exp("dst_port=22", "proto=tcp"),
+		exp("dst_port=22", "proto=udp"),
+		exp("TCP:*->victim-host:22"),
+		exp("tcp:*", "dst_ip~=10.0.0"),
+		exp("tcp:*", "dst_ip=192.168.0.1"),
+	}
+	assert.Equal(t, "", criteria.ExpectedEvents[0].SubType)
+	assert.Equal(t, 2, len(criteria.ExpectedEvents[0].FieldChecks))
+
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+	evt := &types.SimpleEvent{EventType: types.SimpleSchemaNetflow, NetflowFields: &types.SimpleNetflowFields{FlowStr: "tcp:10.0.0.5:41514->10.0.0.1:22", FlowStrDns: "tcp:10.0.0.5:41514->victim-host:22"}}
+	assert.True(t, CheckNetflowEvent(v, evt, ""))
+
+	matched := []int{}
+	for _, e := range v.State.TestData.ExpectedEvents {
+		matched = append(matched, len(e.Matches))
+	}
+	assert.Equal(t, []int{1, 0, 1, 1, 0}, matched)
+
+	// '.' of IP is literal
+	ip := exp("TCP:*->10.0.0.1:22")
+	assert.NotNil(t, ip.SubTypeRegex)
+	assert.True(t, IsLegacyNetflowMatch(ip, evt.NetflowFields))
+	assert.False(t, IsLegacyNetflowMatch(ip, &types.SimpleNetflowFields{FlowStr: "tcp:10.0.0.5:41514->10x0y0z1:22"}))
+}
+
+func TestFieldCheckOperators(t *testing.T) {
+	check := func(haystack string, criteria string) bool {
+		fc, err := utils.ParseFieldCriteria(criteria, "PROCESS")
+		assert.Nil(t, err, criteria)
+		return CheckFieldMatch(haystack, fc)
+	}
+	assert.True(t, check(`C:\Windows\System32\CMD.EXE`, `exe_path:i$=\cmd.exe`))
+	assert.False(t, check(`C:\Windows\System32\CMD.EXE`, `exe_path$=\cmd.exe`))
+	assert.True(t, check("10.1.2.3", "dst_ip<<=192.168.0.0/16, 10.0.0.0/8"))
+
+	// regex compiled for checks not loaded from file, see pkg/utils/fieldcheck_test.go for operators
+	assert.True(t, CheckFieldMatch("/tmp/dir/x.sh", &types.FieldCriteria{FieldName: "path", Op: "%=", Value: "/tmp/*.sh"}))
+
+	_, err := utils.EventFromRow(0, []string{"_E_", "Process", "cmdline*=(", "exe_path^=/bin"})
+	assert.NotNil(t, err)
+}
+
+func TestFileFieldChecks(t *testing.T) {
+	prev := flagFilterFileEventsTmp
+	flagFilterFileEventsTmp = false
+	defer func() { flagFilterFileEventsTmp = prev }()
+
+	exp := func(row ...string) *types.ExpectedEvent {
+		evt, err := utils.EventFromRow(0, append([]string{"_E_", "File"}, row...))
+		assert.Nil(t, err)
+		return &evt
+	}
+	criteria := &types.AtomicTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		exp("READ", "/etc/shadow", "exe_path=/usr/bin/cat"),
+		exp("READ", "/etc/shadow", "exe_path=/usr/bin/less"),
+		exp("CHMOD", "target_path=/tmp/x.sh", "perm_flags=+x"),
+		exp("CHMOD", "perm_flags=+s"),
+		exp("RENAME", "dest_path=/tmp/moved", "pid=42", "exit_code=0"),
+		exp("RENAME", "target_path=/tmp/moved"),
+		exp("DELETE", "path=/root/secret", "exit_code<0", "unique_pid=abc"),
+	}
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+
+	events := []*types.SimpleEvent{
+		{EventType: types.SimpleSchemaFileRead, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionOpenRead, TargetPath: "/etc/shadow", ExePath: "/usr/bin/cat"}},
+		{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionChmod, TargetPath: "/tmp/x.sh", PermFlags: "755"}},
+		{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionRename, TargetPath: "/tmp/x", DestPath: "/tmp/moved", Pid: 42}},
+		{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionDelete, TargetPath: "/root/secret", ExitCode: -13, UniquePid: "abc"}},
+	}
+	for _, evt := range events {
+		assert.True(t, CheckFileEvent(v, evt, ""))
+	}
+
+	matched := []int{}
+	for _, e := range v.State.TestData.ExpectedEvents {
+		matched = append(matched, len(e.Matches))
+	}
+	assert.Equal(t, []int{1, 0, 1, 0, 1, 0, 1}, matched)
+}
+
+func TestHasPermBits(t *testing.T) {
+	assert.True(t, HasPermBits("755", "x"))
+	assert.False(t, HasPermBits("644", "x"))
+	assert.True(t, HasPermBits("4755", "s"))
+	assert.False(t, HasPermBits("0755", "s"))
+	assert.True(t, HasPermBits("-rw-r--r-x", "x"))
+	assert.True(t, HasPermBits("rwsr-xr-x", "s"))
+	assert.False(t, HasPermBits("rw-r--r--", "xs"))
+	assert.False(t, HasPermBits("", "x"))
+}
+
+func TestProcessExitChecks(t *testing.T) {
+	prev := flagFilterByGoartrunShell
+	flagFilterByGoartrunShell = false
+	defer func() { flagFilterByGoartrunShell = prev }()
+
+	exp := func(row ...string) *types.ExpectedEvent {
+		evt, err := utils.EventFromRow(0, append([]string{"_E_", "Process"}, row...))
+		assert.Nil(t, err)
+		return &evt
+	}
+	criteria := &types.AtomicTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		exp("cmdline~=blocked", "exit_code!=0"),
+		exp("cmdline~=blocked", "exit_code=0"),
+		exp("cmdline~=sleep", "duration_ms>=2000"),
+		exp("cmdline~=sleep"),
+	}
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+
+	exitEvent := func(ts, pid int64, exitCode int32) *types.SimpleEvent {
+		return &types.SimpleEvent{EventType: types.SimpleSchemaProcess, Timestamp: ts, ProcessExitFields: &types.SimpleProcessExitFields{Pid: pid, ExitCode: exitCode}}
+	}
+
+	blocked := procEvent(100, 1, "")
+	blocked.ProcessFields.Cmdline = "./blocked"
+	blocked.Timestamp = int64(time.Second)
+	sleep := procEvent(101, 1, "")
+	sleep.ProcessFields.Cmdline = "sleep 3"
+	sleep.Timestamp = int64(time.Second)
+
+	assert.False(t, CheckProcessEvent(v, blocked, ""))
+	assert.True(t, CheckProcessEvent(v, sleep, ""))
+	assert.Equal(t, 0, len(v.State.TestData.ExpectedEvents[0].Matches))
+
+	// exit of other process
+	assert.False(t, CheckProcessEvent(v, exitEvent(int64(2*time.Second), 999, 1), ""))
+
+	assert.True(t, CheckProcessEvent(v, exitEvent(int64(2*time.Second), 100, 126), ""))
+	assert.True(t, CheckProcessEvent(v, exitEvent(int64(4*time.Second), 101, 0), ""))
+
+	matched := []int{}
+	for _, e := range v.State.TestData.ExpectedEvents {
+		matched = append(matched, len(e.Matches))
+	}
+	assert.Equal(t, []int{1, 0, 1, 1}, matched)
+	assert.Equal(t, blocked, v.State.TestData.ExpectedEvents[0].Matches[0])
+	assert.Equal(t, 0, len(v.pendingExits))
+}
+
+func TestNearMisses(t *testing.T) {
+	prev := flagFilterFileEventsTmp
+	flagFilterFileEventsTmp = false
+	defer func() { flagFilterFileEventsTmp = prev }()
+
+	criteria := &types.AtomicTestCriteria{}
+	for i, row := range [][]string{{"WRITE", "path=/tmp/a", "pid=42", "exe_path=/bin/sh"}, {"WRITE", "path=/tmp/b"}} {
+		exp, err := utils.EventFromRow(i, append([]string{"_E_", "File"}, row...))
+		assert.Nil(t, err)
+		criteria.ExpectedEvents = append(criteria.ExpectedEvents, &exp)
+	}
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+
+	write := func(path string, pid int64) *types.SimpleEvent {
+		return &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionOpenWrite, TargetPath: path, Pid: pid}}
+	}
+	events := []*types.SimpleEvent{write("/tmp/c", 1), write("/tmp/a", 1), write("/tmp/c", 42), write("/tmp/a", 42), write("/tmp/a", 3), write("/tmp/a", 4), write("/tmp/a", 5), write("/tmp/c", 5), write("/tmp/b", 6)}
+	for _, evt := range events {
+		CheckFileEvent(v, evt, "")
+	}
+
+	// events satisfying no field checks are not kept
+	a := v.NearMisses(v.State.TestData.ExpectedEvents[0])
+	assert.Equal(t, kMaxNearMisses, len(a))
+	assert.Equal(t, []*types.SimpleEvent{events[3], events[1], events[2], events[4], events[5]}, []*types.SimpleEvent{a[0].Event, a[1].Event, a[2].Event, a[3].Event, a[4].Event})
+	assert.Equal(t, 2, a[0].NumChecksSatisfied)
+	assert.Equal(t, 3, a[0].NumChecks)
+	assert.Equal(t, []FailedCheck{{FieldName: "exe_path", Op: "=", Expected: "/bin/sh", Actual: ""}}, a[0].FailedChecks)
+	assert.Equal(t, []FailedCheck{{FieldName: "pid", Op: "=", Expected: "42", Actual: "1"}, {FieldName: "exe_path", Op: "=", Expected: "/bin/sh", Actual: ""}}, a[1].FailedChecks)
+
+	// matched
+	assert.Nil(t, v.NearMisses(v.State.TestData.ExpectedEvents[1]))
+
+	v.testRun.resultsDir = t.TempDir()
+	WriteNearMisses(v)
+	data, err := os.ReadFile(filepath.Join(v.testRun.resultsDir, "near_misses.json"))
+	assert.Nil(t, err)
+	reports := []*NearMissReport{}
+	assert.Nil(t, json.Unmarshal(data, &reports))
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, "0", reports[0].Id)
+	assert.Equal(t, kMaxNearMisses, len(reports[0].Candidates))
+	assert.Equal(t, "1", reports[0].Candidates[1].FailedChecks[0].Actual)
+}
+
+func TestFileNearMissChecks(t *testing.T) {
+	prev := flagFilterFileEventsTmp
+	flagFilterFileEventsTmp = false
+	defer func() { flagFilterFileEventsTmp = prev }()
+
+	criteria := &types.AtomicTestCriteria{}
+	exp, err := utils.EventFromRow(0, []string{"_E_", "File", "WRITE", "path=/tmp/moved", "perm_flags=+x", "pid=42"})
+	assert.Nil(t, err)
+	criteria.ExpectedEvents = []*types.ExpectedEvent{&exp}
+	v := NewValidator(&SingleTestRun{criteria: criteria}, &TelemTool{})
+
+	// path is the dest path, and perm_flags has an x bit, as in CheckFileEvent()
+	evt := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, FileFields: &types.SimpleFileFields{Action: types.SimpleFileActionRename, TargetPath: "/tmp/x", DestPath: "/tmp/moved", PermFlags: "0750", Pid: 7}}
+	assert.False(t, CheckFileEvent(v, evt, ""))
+
+	a := v.NearMisses(v.State.TestData.ExpectedEvents[0])
+	assert.Equal(t, 1, len(a))
+	assert.Equal(t, 2, a[0].NumChecksSatisfied)
+	assert.Equal(t, []FailedCheck{{FieldName: "pid", Op: "=", Expected: "42", Actual: "7"}}, a[0].FailedChecks)
+
+	fields := EventFieldValues(evt)
+	for _, fc := range exp.FieldChecks {
+		assert.Equal(t, CheckFileFieldMatch(evt.FileFields, &fc), IsFieldCheckSatisfied(evt, fields, &fc), fc.FieldName)
+	}
+}
+
+func TestSequenceCorrelation(t *testing.T) {
+	at := func(seconds ...int64) []*types.SimpleEvent {
+		a := []*types.SimpleEvent{}
+		for _, s := range seconds {
+			a = append(a, &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: s * int64(time.Second)})
+		}
+		return a
+	}
+	criteria := &types.MitreTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{
+		{Id: "0", EventType: "File", SubType: "WRITE", Matches: at(5, 10)},
+		{Id: "1", EventType: "File", SubType: "CHMOD", Matches: at(8)},
+		{Id: "2", EventType: "Process", Matches: at(12)},
+	}
+	row := func(cols ...string) *types.CorrelationRow {
+		corr, err := utils.CorrelationFromRow(len(criteria.ExpectedCorrelations), append([]string{"_C_", "Sequence"}, cols...))
+		assert.Nil(t, err)
+		return &corr
+	}
+	criteria.ExpectedCorrelations = []*types.CorrelationRow{
+		row("Before", "0", "1", "2"),
+		row("Before", "1", "0", "2"),
+		row("Before", "2", "0"),
+		row("After", "2", "1", "0"),
+		row("Before", "0", "1", "2", "maxgap=4s"),
+		row("Before", "0", "1", "2", "maxgap=2"),
+	}
+	assert.Equal(t, int64(4*time.Second), criteria.ExpectedCorrelations[4].MaxGapNs)
+
+	EvaluateCorrelations(criteria)
+
+	met := []bool{}
+	for _, corr := range criteria.ExpectedCorrelations {
+		met = append(met, corr.IsMet)
+	}
+	assert.Equal(t, []bool{true, true, false, true, true, false}, met)
+
+	_, err := utils.CorrelationFromRow(0, []string{"_C_", "Sequence", "Before", "0", "1", "maxgap=soon"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,377 @@
+package main
+
+/*
+ * results-diff compares two harness results directories, e.g. before
+ * and after an agent upgrade, using status.json and the
+ * validate_summary.json of each test.
+ *
+ * For each test it prints the status change, like Validated -> Partial,
+ * and the expected events that were lost or gained.  Expected events are
+ * compared by event type, sub type and field checks, rather than by id,
+ * so that criteria rows can be reordered.  Optional (_?_) events are
+ * prefixed with '?', and negated (_N_) events with '!'.  A negated event
+ * is lost when it is now seen.  Expected events in only one of the runs
+ * are listed as criteria added or removed, as the criteria changed, and
+ * are not lost or gained.
+ *
+ * A test regressed when its validation status got worse, including
+ * from a validation status to one where it was not validated, like
+ * TestFail, or a required or negated expected event was lost.  A
+ * Validated or Partial test that was removed is also a regression.
+ * Exits 1 if there are any regressions.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+)
+
+const kExitRegressed = 1
+
+var flagSuffix string
+var flagVerbose bool
+
+func init() {
+	flag.StringVar(&flagSuffix, "suffix", "", "suffix of telemetry tool, to compare validate_summary{suffix}.json and its status")
+	flag.BoolVar(&flagVerbose, "verbose", false, "also print unchanged tests")
+}
+
+type DiffKind string
+
+const (
+	DiffRegressed DiffKind = "Regressed"
+	DiffImproved  DiffKind = "Improved"
+	DiffChanged   DiffKind = "Changed" // from a status that was not validated
+	DiffUnchanged DiffKind = "Unchanged"
+	DiffAdded     DiffKind = "Added"
+	DiffRemoved   DiffKind = "Removed"
+)
+
+var kDiffKinds = []DiffKind{DiffRegressed, DiffImproved, DiffChanged, DiffUnchanged, DiffAdded, DiffRemoved}
+
+// validate_summary.json fields used here
+type ValidateSummary struct {
+	TestData types.MitreTestCriteria `json:"test_data"`
+	Coverage float64                 `json:"coverage"`
+}
+
+type TestResult struct {
+	Technique string
+	TestIndex string
+	TestName  string
+	Status    types.TestStatus
+	Events    map[string]bool // description of expected event -> seen. nil without validate_summary.json
+}
+
+type TestDiff struct {
+	Key    string
+	Kind   DiffKind
+	Before *TestResult
+	After  *TestResult
+	Lost   []string
+	Gained []string
+
+	CriteriaAdded   []string // expected events only in after
+	CriteriaRemoved []string // expected events only in before
+}
+
+/*
+ * StatusRank orders validation statuses from worst to best.  Other
+ * statuses, where the test was not validated, are 0.
+ */
+func StatusRank(status types.TestStatus) int {
+	switch status {
+	case types.StatusValidateUnexpected:
+		return 1
+	case types.StatusValidateFail:
+		return 2
+	case types.StatusValidatePartial:
+		return 3
+	case types.StatusValidateSuccess:
+		return 4
+	}
+	return 0
+}
+
+/*
+ * DescribeExpectedEvent returns a description of exp without its id,
+ * like "?File WRITE path=/tmp/a"
+ */
+func DescribeExpectedEvent(exp *types.ExpectedEvent) string {
+	s := exp.EventType
+	if exp.IsNegated {
+		s = "!" + s
+	} else if exp.IsMaybe {
+		s = "?" + s
+	}
+	if exp.SubType != "" {
+		s += " " + exp.SubType
+	}
+	for _, fc := range exp.FieldChecks {
+		s += " " + fc.FieldName + fc.Op + fc.Value
+	}
+	return s
+}
+
+/*
+ * TestDir returns the results dir of test.  Older versions of harness
+ * appended TestGuid.
+ */
+func TestDir(resultsDir string, p *types.TestProgress) string {
+	dir := filepath.FromSlash(resultsDir + "/" + p.Technique + "_" + p.TestIndex)
+	if _, err := os.Stat(dir); err != nil && p.TestGuid != "" {
+		dir += "_" + p.TestGuid
+	}
+	return dir
+}
+
+/*
+ * LoadResults loads the results of each test in resultsDir, keyed by
+ * Technique#TestIndex.  Also returns the keys in order of status.json.
+ */
+func LoadResults(resultsDir string, suffix string) (map[string]*TestResult, []string, error) {
+	progress := []types.TestProgress{}
+	err := utils.LoadTestProgress(resultsDir, &progress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := map[string]*TestResult{}
+	keys := []string{}
+	for i := range progress {
+		p := &progress[i]
+		res := &TestResult{Technique: p.Technique, TestIndex: p.TestIndex, TestName: p.TestName, Status: p.Status}
+		if status, ok := p.ToolStatus[suffix]; ok {
+			res.Status = status
+		}
+
+		summary := ValidateSummary{}
+		if utils.LoadValidateSummary(TestDir(resultsDir, p), suffix, &summary) == nil {
+			res.Events = map[string]bool{}
+			for _, exp := range summary.TestData.ExpectedEvents {
+				desc := DescribeExpectedEvent(exp)
+				for n := 2; ; n++ {
+					if _, ok := res.Events[desc]; !ok {
+						break
+					}
+					desc = fmt.Sprintf("%s (%d)", DescribeExpectedEvent(exp), n)
+				}
+				res.Events[desc] = len(exp.Matches) > 0
+			}
+		}
+
+		key := p.Technique + "#" + p.TestIndex
+		if _, ok := results[key]; !ok {
+			keys = append(keys, key)
+		}
+		results[key] = res
+	}
+	return results, keys, nil
+}
+
+/*
+ * DiffEvents returns the expected events lost and gained from before to
+ * after, and those added to or removed from the criteria, in order of
+ * descriptions.  isRegressed is true if a required or negated event was
+ * lost, isImproved if one was gained.  Criteria added or removed do not
+ * count towards either.
+ */
+func DiffEvents(before, after map[string]bool) (lost, gained, added, removed []string, isRegressed, isImproved bool) {
+	if before == nil || after == nil {
+		return
+	}
+	descs := []string{}
+	for desc := range before {
+		descs = append(descs, desc)
+	}
+	for desc := range after {
+		if _, ok := before[desc]; !ok {
+			descs = append(descs, desc)
+		}
+	}
+	sort.Strings(descs)
+
+	for _, desc := range descs {
+		wasSeen, inBefore := before[desc]
+		isSeen, inAfter := after[desc]
+		if !inBefore {
+			added = append(added, desc)
+			continue
+		}
+		if !inAfter {
+			removed = append(removed, desc)
+			continue
+		}
+		if wasSeen == isSeen {
+			continue
+		}
+		isNegated := strings.HasPrefix(desc, "!")
+		isMaybe := strings.HasPrefix(desc, "?")
+		if wasSeen != isNegated {
+			lost = append(lost, desc)
+			isRegressed = isRegressed || !isMaybe
+		} else {
+			gained = append(gained, desc)
+			isImproved = isImproved || !isMaybe
+		}
+	}
+	return
+}
+
+/*
+ * DiffTest compares results of a test.  Either may be nil.
+ */
+func DiffTest(key string, before, after *TestResult) *TestDiff {
+	d := &TestDiff{Key: key, Before: before, After: after, Kind: DiffUnchanged}
+	if before == nil {
+		d.Kind = DiffAdded
+		return d
+	}
+	if after == nil {
+		d.Kind = DiffRemoved
+		return d
+	}
+
+	var isRegressed, isImproved bool
+	d.Lost, d.Gained, d.CriteriaAdded, d.CriteriaRemoved, isRegressed, isImproved = DiffEvents(before.Events, after.Events)
+
+	rankBefore, rankAfter := StatusRank(before.Status), StatusRank(after.Status)
+	switch {
+	case rankBefore == 0:
+		if before.Status != after.Status {
+			d.Kind = DiffChanged
+		}
+	case rankAfter < rankBefore || isRegressed:
+		d.Kind = DiffRegressed
+	case rankAfter > rankBefore || isImproved:
+		d.Kind = DiffImproved
+	}
+	return d
+}
+
+/*
+ * IsRegression returns true if test regressed, or was Validated or
+ * Partial and is missing from after.
+ */
+func (d *TestDiff) IsRegression() bool {
+	if d.Kind == DiffRemoved {
+		return StatusRank(d.Before.Status) >= StatusRank(types.StatusValidatePartial)
+	}
+	return d.Kind == DiffRegressed
+}
+
+/*
+ * DiffResults compares results of each test, in order of after, then
+ * tests removed from before.
+ */
+func DiffResults(before map[string]*TestResult, beforeKeys []string, after map[string]*TestResult, afterKeys []string) []*TestDiff {
+	diffs := []*TestDiff{}
+	for _, key := range afterKeys {
+		diffs = append(diffs, DiffTest(key, before[key], after[key]))
+	}
+	for _, key := range beforeKeys {
+		if _, ok := after[key]; !ok {
+			diffs = append(diffs, DiffTest(key, before[key], nil))
+		}
+	}
+	return diffs
+}
+
+/*
+ * SPrintDiff returns the lines of test diff, like
+ *   Regressed  T1053.003 #1 Validated -> Partial "Cron - Add script"
+ *       lost: F WRITE path=/tmp/a
+ *   criteria removed: F WRITE path=/tmp/b
+ */
+func SPrintDiff(d *TestDiff) string {
+	res := d.After
+	if res == nil {
+		res = d.Before
+	}
+	change := ""
+	switch {
+	case d.Before == nil:
+		change = "-> " + d.After.Status.String()
+	case d.After == nil:
+		change = d.Before.Status.String() + " ->"
+	case d.Before.Status != d.After.Status:
+		change = d.Before.Status.String() + " -> " + d.After.Status.String()
+	default:
+		change = d.After.Status.String()
+	}
+	s := fmt.Sprintf("%-10s %s #%s %s \"%s\"\n", d.Kind, res.Technique, res.TestIndex, change, res.TestName)
+	for _, desc := range d.Lost {
+		s += "    lost: " + desc + "\n"
+	}
+	for _, desc := range d.Gained {
+		s += "  gained: " + desc + "\n"
+	}
+	for _, desc := range d.CriteriaAdded {
+		s += "  criteria added: " + desc + "\n"
+	}
+	for _, desc := range d.CriteriaRemoved {
+		s += "criteria removed: " + desc + "\n"
+	}
+	return s
+}
+
+/*
+ * PrintDiffs prints tests that changed, and totals.  Returns number of
+ * regressions, see IsRegression().
+ */
+func PrintDiffs(diffs []*TestDiff) int {
+	counts := map[DiffKind]int{}
+	numRegressions := 0
+	for _, d := range diffs {
+		counts[d.Kind] += 1
+		if d.IsRegression() {
+			numRegressions += 1
+		}
+		if d.Kind != DiffUnchanged || len(d.Lost) > 0 || len(d.Gained) > 0 || len(d.CriteriaAdded) > 0 || len(d.CriteriaRemoved) > 0 || flagVerbose {
+			fmt.Print(SPrintDiff(d))
+		}
+	}
+	totals := []string{}
+	for _, kind := range kDiffKinds {
+		totals = append(totals, fmt.Sprintf("%s:%d", kind, counts[kind]))
+	}
+	totals = append(totals, fmt.Sprintf("Regressions:%d", numRegressions))
+	fmt.Println("===", strings.Join(totals, " "))
+	return numRegressions
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: results-diff [options] <before results dir> <after results dir>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	before, beforeKeys, err := LoadResults(flag.Arg(0), flagSuffix)
+	if err != nil {
+		fmt.Println("ERROR: unable to load results", flag.Arg(0), err)
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+	after, afterKeys, err := LoadResults(flag.Arg(1), flagSuffix)
+	if err != nil {
+		fmt.Println("ERROR: unable to load results", flag.Arg(1), err)
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	numRegressions := PrintDiffs(DiffResults(before, beforeKeys, after, afterKeys))
+	if numRegressions > 0 {
+		os.Exit(kExitRegressed)
+	}
+}
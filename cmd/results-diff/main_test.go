@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeJson(t *testing.T, path string, obj interface{}) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	j, err := json.Marshal(obj)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path, j, 0644))
+}
+
+// writes status.json and validate_summary.json of a test in T1000_1,
+// with an expected event per path that is seen if in seenPaths
+func writeResults(t *testing.T, dir string, status types.TestStatus, seenPaths map[string]bool, paths ...string) {
+	progress := []types.TestProgress{{Technique: "T1000", TestIndex: "1", TestName: "test one", Status: status}}
+	writeJson(t, filepath.Join(dir, "status.json"), progress)
+
+	summary := ValidateSummary{TestData: types.MitreTestCriteria{Technique: "T1000", TestIndex: 1}}
+	for i, path := range paths {
+		exp := &types.ExpectedEvent{Id: string(rune('0' + i)), EventType: "File", SubType: "WRITE"}
+		exp.FieldChecks = []types.FieldCriteria{{FieldName: "path", Op: "=", Value: path}}
+		if path == "/tmp/maybe" {
+			exp.IsMaybe = true
+		}
+		if seenPaths[path] {
+			exp.Matches = []*types.SimpleEvent{{EventType: types.SimpleSchemaFilemod}}
+		}
+		summary.TestData.ExpectedEvents = append(summary.TestData.ExpectedEvents, exp)
+	}
+	writeJson(t, filepath.Join(dir, "T1000_1", "validate_summary.json"), summary)
+}
+
+func TestDiffEvents(t *testing.T) {
+	before := map[string]bool{"File WRITE path=/tmp/a": true, "?File WRITE path=/tmp/b": true, "!Process exe=/bin/rm": false}
+	after := map[string]bool{"File WRITE path=/tmp/a": false, "?File WRITE path=/tmp/b": false, "!Process exe=/bin/rm": true}
+	lost, gained, added, removed, isRegressed, isImproved := DiffEvents(before, after)
+	assert.Equal(t, []string{"!Process exe=/bin/rm", "?File WRITE path=/tmp/b", "File WRITE path=/tmp/a"}, lost)
+	assert.Equal(t, 0, len(gained))
+	assert.Equal(t, 0, len(added))
+	assert.Equal(t, 0, len(removed))
+	assert.True(t, isRegressed)
+	assert.False(t, isImproved)
+
+	// optional only
+	lost, gained, _, _, isRegressed, isImproved = DiffEvents(map[string]bool{"?F": false}, map[string]bool{"?F": true})
+	assert.Equal(t, 0, len(lost))
+	assert.Equal(t, []string{"?F"}, gained)
+	assert.False(t, isRegressed)
+	assert.False(t, isImproved)
+
+	// criteria changed, events in one run only are not lost or gained
+	lost, gained, added, removed, isRegressed, isImproved = DiffEvents(map[string]bool{"F path=/tmp/a": true, "F path=/tmp/b": true}, map[string]bool{"F path=/tmp/a": true, "F path=/tmp/c": false, "F path=/tmp/d": true})
+	assert.Equal(t, 0, len(lost))
+	assert.Equal(t, 0, len(gained))
+	assert.Equal(t, []string{"F path=/tmp/c", "F path=/tmp/d"}, added)
+	assert.Equal(t, []string{"F path=/tmp/b"}, removed)
+	assert.False(t, isRegressed)
+	assert.False(t, isImproved)
+}
+
+func TestDiffResults(t *testing.T) {
+	beforeDir, afterDir := t.TempDir(), t.TempDir()
+	writeResults(t, beforeDir, types.StatusValidateSuccess, map[string]bool{"/tmp/a": true, "/tmp/b": true, "/tmp/maybe": true}, "/tmp/a", "/tmp/b", "/tmp/maybe")
+	writeResults(t, afterDir, types.StatusValidatePartial, map[string]bool{"/tmp/a": true}, "/tmp/a", "/tmp/b", "/tmp/maybe")
+
+	before, beforeKeys, err := LoadResults(beforeDir, "")
+	assert.Nil(t, err)
+	after, afterKeys, err := LoadResults(afterDir, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"T1000#1"}, afterKeys)
+
+	diffs := DiffResults(before, beforeKeys, after, afterKeys)
+	assert.Equal(t, 1, len(diffs))
+	assert.Equal(t, DiffRegressed, diffs[0].Kind)
+	assert.Equal(t, []string{"?File WRITE path=/tmp/maybe", "File WRITE path=/tmp/b"}, diffs[0].Lost)
+	assert.Equal(t, "Regressed  T1000 #1 Validated -> Partial \"test one\"\n    lost: ?File WRITE path=/tmp/maybe\n    lost: File WRITE path=/tmp/b\n", SPrintDiff(diffs[0]))
+
+	// reversed is an improvement
+	diffs = DiffResults(after, afterKeys, before, beforeKeys)
+	assert.Equal(t, DiffImproved, diffs[0].Kind)
+	assert.Equal(t, 2, len(diffs[0].Gained))
+
+	// losing only an optional event is not a regression
+	writeResults(t, afterDir, types.StatusValidateSuccess, map[string]bool{"/tmp/a": true, "/tmp/b": true}, "/tmp/a", "/tmp/b", "/tmp/maybe")
+	after, afterKeys, err = LoadResults(afterDir, "")
+	assert.Nil(t, err)
+	diffs = DiffResults(before, beforeKeys, after, afterKeys)
+	assert.Equal(t, DiffUnchanged, diffs[0].Kind)
+	assert.Equal(t, []string{"?File WRITE path=/tmp/maybe"}, diffs[0].Lost)
+
+	// editing a criteria row that matched is not a regression
+	writeResults(t, afterDir, types.StatusValidateSuccess, map[string]bool{"/tmp/a": true, "/tmp/maybe": true}, "/tmp/a", "/tmp/c", "/tmp/maybe")
+	after, afterKeys, err = LoadResults(afterDir, "")
+	assert.Nil(t, err)
+	diffs = DiffResults(before, beforeKeys, after, afterKeys)
+	assert.Equal(t, DiffUnchanged, diffs[0].Kind)
+	assert.False(t, diffs[0].IsRegression())
+	assert.Equal(t, 0, len(diffs[0].Lost))
+	assert.Equal(t, []string{"File WRITE path=/tmp/c"}, diffs[0].CriteriaAdded)
+	assert.Equal(t, []string{"File WRITE path=/tmp/b"}, diffs[0].CriteriaRemoved)
+	assert.Equal(t, "Unchanged  T1000 #1 Validated \"test one\"\n  criteria added: File WRITE path=/tmp/c\ncriteria removed: File WRITE path=/tmp/b\n", SPrintDiff(diffs[0]))
+
+	// removing a Validated test is a regression
+	diffs = DiffResults(before, beforeKeys, map[string]*TestResult{}, nil)
+	assert.Equal(t, DiffRemoved, diffs[0].Kind)
+	assert.True(t, diffs[0].IsRegression())
+	notValidated := map[string]*TestResult{"T1000#1": {Technique: "T1000", TestIndex: "1", Status: types.StatusTestFail}}
+	diffs = DiffResults(notValidated, beforeKeys, map[string]*TestResult{}, nil)
+	assert.False(t, diffs[0].IsRegression())
+
+	// to a status that was not validated is a regression, from one is a change
+	for _, status := range []types.TestStatus{types.StatusTestFail, types.StatusRunnerFailure, types.StatusTelemetryToolFailure} {
+		failed := map[string]*TestResult{"T1000#1": {Technique: "T1000", TestIndex: "1", Status: status}}
+		diffs = DiffResults(before, beforeKeys, failed, afterKeys)
+		assert.Equal(t, DiffRegressed, diffs[0].Kind, status.String())
+		assert.True(t, diffs[0].IsRegression())
+		diffs = DiffResults(failed, afterKeys, before, beforeKeys)
+		assert.Equal(t, DiffChanged, diffs[0].Kind, status.String())
+		assert.False(t, diffs[0].IsRegression())
+	}
+
+	_, _, err = LoadResults(filepath.Join(beforeDir, "nope"), "")
+	assert.NotNil(t, err)
+}
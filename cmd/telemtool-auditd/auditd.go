@@ -0,0 +1,364 @@
+package main
+
+/*
+ * Parsing of audit.log records and conversion of audit events to
+ * the simple schema used by the harness.
+ *
+ * An audit event is made up of records with the same serial, e.g.
+ *   type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=59 success=yes ...
+ *   type=EXECVE msg=audit(1364481363.243:24287): argc=2 a0="ls" a1=2D6C61
+ *   type=CWD msg=audit(1364481363.243:24287): cwd="/root"
+ *   type=PATH msg=audit(1364481363.243:24287): item=0 name="/bin/ls" nametype=NORMAL ...
+ *   type=EOE msg=audit(1364481363.243:24287):
+ */
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+// records of events without an EOE record are flushed when this many are pending
+var kMaxPendingEvents = 256
+
+var gRxAuditMsg = regexp.MustCompile(`^type=(\w+) msg=audit\((\d+)\.(\d+):(\d+)\):\s*`)
+
+const (
+	kOpenWriteOnly = 0x1
+	kOpenReadWrite = 0x2
+)
+
+type AuditRecord struct {
+	Type   string
+	Line   string
+	Fields map[string]string // quoted values keep their quotes, see Str()
+}
+
+type AuditEvent struct {
+	Serial    uint64
+	Timestamp int64 // nanoseconds
+	Records   []*AuditRecord
+}
+
+/*
+ * ParseAuditLine parses a line of audit.log.  Fields of the enriched
+ * log format, after 0x1d separator, are included with uppercase keys.
+ */
+func ParseAuditLine(line string) (*AuditRecord, int64, uint64, error) {
+	m := gRxAuditMsg.FindStringSubmatch(line)
+	if m == nil {
+		return nil, 0, 0, fmt.Errorf("not an audit record: %s", line)
+	}
+	sec, _ := strconv.ParseInt(m[2], 10, 64)
+	msec, _ := strconv.ParseInt(m[3], 10, 64)
+	serial, _ := strconv.ParseUint(m[4], 10, 64)
+
+	rec := &AuditRecord{Type: m[1], Line: line, Fields: map[string]string{}}
+	for _, part := range strings.Split(line[len(m[0]):], "\x1d") {
+		ParseAuditFields(part, rec.Fields)
+	}
+	return rec, sec*1000000000 + msec*1000000, serial, nil
+}
+
+/*
+ * ParseAuditFields parses space separated key=value pairs of s into dest.
+ */
+func ParseAuditFields(s string, dest map[string]string) {
+	for {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		end := strings.IndexByte(s, ' ')
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			end = strings.IndexByte(s[1:], s[0])
+			if end >= 0 {
+				end += 2
+			}
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		dest[key] = s[:end]
+		s = s[end:]
+	}
+}
+
+/*
+ * Str returns the string value of field.  Values that are not quoted
+ * are hex encoded by auditd, e.g. paths containing spaces.
+ */
+func (r *AuditRecord) Str(key string) string {
+	val, ok := r.Fields[key]
+	if !ok || val == "(null)" {
+		return ""
+	}
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		return val[1 : len(val)-1]
+	}
+	data, err := hex.DecodeString(val)
+	if err != nil {
+		return val
+	}
+	return string(data)
+}
+
+func (r *AuditRecord) Int(key string) int64 {
+	val, _ := strconv.ParseInt(r.Fields[key], 10, 64)
+	return val
+}
+
+// Hex returns value of syscall args a0..a3, which are hex
+func (r *AuditRecord) Hex(key string) int64 {
+	val, _ := strconv.ParseUint(r.Fields[key], 16, 64)
+	return int64(val)
+}
+
+func (e *AuditEvent) Find(recordType string) *AuditRecord {
+	for _, rec := range e.Records {
+		if rec.Type == recordType {
+			return rec
+		}
+	}
+	return nil
+}
+
+/*
+ * FindPath returns the name of the first PATH record with one of
+ * nametypes, made absolute using the CWD record.
+ */
+func (e *AuditEvent) FindPath(nametypes ...string) (string, string) {
+	for _, nametype := range nametypes {
+		for _, rec := range e.Records {
+			if rec.Type != "PATH" || rec.Fields["nametype"] != nametype {
+				continue
+			}
+			name := rec.Str("name")
+			if len(name) == 0 {
+				continue
+			}
+			if !path.IsAbs(name) {
+				if cwd := e.Find("CWD"); cwd != nil {
+					name = path.Join(cwd.Str("cwd"), name)
+				}
+			}
+			return name, nametype
+		}
+	}
+	return "", ""
+}
+
+/*
+ * Cmdline returns the args of EXECVE record joined by spaces, or the
+ * PROCTITLE if there is no EXECVE record.
+ */
+func (e *AuditEvent) Cmdline() string {
+	if rec := e.Find("EXECVE"); rec != nil {
+		args := []string{}
+		argc := int(rec.Int("argc"))
+		for i := 0; i < argc; i++ {
+			key := fmt.Sprintf("a%d", i)
+			if _, ok := rec.Fields[key+"_len"]; ok {
+				// long args are split into a1[0]=.. a1[1]=..
+				arg := ""
+				for j := 0; ; j++ {
+					chunkKey := fmt.Sprintf("%s[%d]", key, j)
+					if _, ok := rec.Fields[chunkKey]; !ok {
+						break
+					}
+					arg += rec.Str(chunkKey)
+				}
+				args = append(args, arg)
+				continue
+			}
+			args = append(args, rec.Str(key))
+		}
+		return strings.Join(args, " ")
+	}
+	if rec := e.Find("PROCTITLE"); rec != nil {
+		return strings.TrimSpace(strings.ReplaceAll(rec.Str("proctitle"), "\x00", " "))
+	}
+	return ""
+}
+
+/*
+ * ReadAuditEvents reads audit.log lines from r, calling fn with each
+ * event once all of its records are read.
+ */
+func ReadAuditEvents(r io.Reader, fn func(*AuditEvent)) error {
+	pending := map[uint64]*AuditEvent{}
+	order := []uint64{}
+
+	flush := func(serial uint64) {
+		evt, ok := pending[serial]
+		if !ok {
+			return
+		}
+		delete(pending, serial)
+		fn(evt)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		rec, ts, serial, err := ParseAuditLine(line)
+		if err != nil {
+			if flagVerbose {
+				fmt.Println("WARN:", err)
+			}
+			continue
+		}
+		if rec.Type == "EOE" {
+			flush(serial)
+			continue
+		}
+		evt, ok := pending[serial]
+		if !ok {
+			evt = &AuditEvent{Serial: serial, Timestamp: ts}
+			pending[serial] = evt
+			order = append(order, serial)
+		}
+		evt.Records = append(evt.Records, rec)
+
+		// single record events have no EOE
+
+		for len(pending) > kMaxPendingEvents && len(order) > 0 {
+			flush(order[0])
+			order = order[1:]
+		}
+	}
+	for _, serial := range order {
+		flush(serial)
+	}
+	return scanner.Err()
+}
+
+/*
+ * ConvertAuditEvent returns the simple schema event for audit event,
+ * or nil if the syscall of the event is not supported.
+ */
+func ConvertAuditEvent(evt *AuditEvent) *types.SimpleEvent {
+	syscall := evt.Find("SYSCALL")
+	if syscall == nil {
+		return nil
+	}
+	name := strings.ToLower(syscall.Fields["SYSCALL"])
+	if len(name) == 0 {
+		name = GetSyscallName(syscall.Fields["arch"], int(syscall.Int("syscall")))
+	}
+
+	isSuccess := syscall.Fields["success"] != "no"
+	exitCode := int32(0)
+	if !isSuccess {
+		exitCode = int32(syscall.Int("exit"))
+	}
+
+	retval := &types.SimpleEvent{EventType: types.SimpleSchemaFilemod, Timestamp: evt.Timestamp}
+	fields := &types.SimpleFileFields{ExitCode: exitCode, Pid: syscall.Int("pid"), ExePath: syscall.Str("exe")}
+
+	switch name {
+	case "execve", "execveat":
+		if !isSuccess {
+			return nil
+		}
+		retval.EventType = types.SimpleSchemaProcess
+		retval.ProcessFields = &types.SimpleProcessFields{
+			Cmdline:    evt.Cmdline(),
+			Pid:        syscall.Int("pid"),
+			ParentPid:  syscall.Int("ppid"),
+			ExePath:    syscall.Str("exe"),
+			IsElevated: syscall.Fields["euid"] == "0",
+		}
+		return retval
+
+	case "open", "openat", "openat2", "creat":
+		flags := int64(-1)
+		switch name {
+		case "open":
+			flags = syscall.Hex("a1")
+		case "openat":
+			flags = syscall.Hex("a2")
+		case "openat2":
+			// flags are in struct open_how, logged in OPENAT2 record since linux 5.16
+			if rec := evt.Find("OPENAT2"); rec != nil {
+				flags, _ = strconv.ParseInt(rec.Fields["oflag"], 8, 64)
+			}
+		}
+		var nametype string
+		fields.TargetPath, nametype = evt.FindPath("CREATE", "NORMAL")
+		if "openat2" == name && flags < 0 && "CREATE" != nametype {
+			return nil // unknown if read or write
+		}
+		if "creat" == name || "CREATE" == nametype {
+			fields.Action = types.SimpleFileActionCreate
+		} else if flags > 0 && (flags&kOpenWriteOnly != 0 || flags&kOpenReadWrite != 0) {
+			fields.Action = types.SimpleFileActionOpenWrite
+		} else {
+			fields.Action = types.SimpleFileActionOpenRead
+			retval.EventType = types.SimpleSchemaFileRead
+		}
+
+	case "unlink", "unlinkat", "rmdir":
+		fields.Action = types.SimpleFileActionDelete
+		fields.TargetPath, _ = evt.FindPath("DELETE", "NORMAL")
+
+	case "rename", "renameat", "renameat2":
+		fields.Action = types.SimpleFileActionRename
+		fields.TargetPath, _ = evt.FindPath("DELETE")
+		fields.DestPath, _ = evt.FindPath("CREATE")
+
+	case "mkdir", "mkdirat", "link", "linkat", "symlink", "symlinkat":
+		fields.Action = types.SimpleFileActionCreate
+		fields.TargetPath, _ = evt.FindPath("CREATE")
+
+	case "chmod", "fchmod", "fchmodat":
+		fields.Action = types.SimpleFileActionChmod
+		fields.TargetPath, _ = evt.FindPath("NORMAL")
+		modeArg := "a1"
+		if "fchmodat" == name {
+			modeArg = "a2"
+		}
+		fields.PermFlags = fmt.Sprintf("%o", syscall.Hex(modeArg))
+
+	case "chown", "fchown", "lchown", "fchownat":
+		fields.Action = types.SimpleFileActionChown
+		fields.TargetPath, _ = evt.FindPath("NORMAL")
+
+	case "truncate", "ftruncate":
+		fields.Action = types.SimpleFileActionTruncate
+		fields.TargetPath, _ = evt.FindPath("NORMAL")
+
+	case "setxattr", "lsetxattr", "fsetxattr", "removexattr", "lremovexattr", "fremovexattr":
+		fields.Action = types.SimpleFileActionChattr
+		fields.TargetPath, _ = evt.FindPath("NORMAL")
+
+	default:
+		return nil
+	}
+
+	// syscalls on file descriptors have no PATH record
+
+	if len(fields.TargetPath) == 0 {
+		if flagVerbose {
+			fmt.Println("no path for", name, "serial", evt.Serial)
+		}
+		return nil
+	}
+	retval.FileFields = fields
+	return retval
+}
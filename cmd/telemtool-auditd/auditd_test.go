@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAuditLine(t *testing.T) {
+	rec, ts, serial, err := ParseAuditLine(`type=PATH msg=audit(1700000000.123:42): item=0 name=2F746D702F6D792066696C65 nametype=NORMAL obj=(null) msg='op=x res=success'` + "\x1dOUID=\"root\"")
+	assert.Nil(t, err)
+	assert.Equal(t, "PATH", rec.Type)
+	assert.Equal(t, int64(1700000000123000000), ts)
+	assert.Equal(t, uint64(42), serial)
+	assert.Equal(t, "/tmp/my file", rec.Str("name"))
+	assert.Equal(t, "NORMAL", rec.Fields["nametype"])
+	assert.Equal(t, "", rec.Str("obj"))
+	assert.Equal(t, "op=x res=success", rec.Str("msg"))
+	assert.Equal(t, "root", rec.Str("OUID"))
+	assert.Equal(t, int64(0), rec.Int("item"))
+
+	_, _, _, err = ParseAuditLine("garbage")
+	assert.NotNil(t, err)
+}
+
+func TestConvertAuditLog(t *testing.T) {
+	start, end, err := utils.ParseTimeRange("1700000000,1700000010")
+	assert.Nil(t, err)
+
+	events, err := ConvertAuditLog("testdata/audit.log", start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, len(events))
+
+	// execve with hex encoded arg
+	evt := events[0].Event
+	assert.Equal(t, types.SimpleSchemaProcess, evt.EventType)
+	assert.Equal(t, int64(1700000000100000000), evt.Timestamp)
+	assert.Equal(t, "cat /tmp/my file", evt.ProcessFields.Cmdline)
+	assert.Equal(t, int64(1001), evt.ProcessFields.Pid)
+	assert.Equal(t, int64(1000), evt.ProcessFields.ParentPid)
+	assert.Equal(t, "/usr/bin/cat", evt.ProcessFields.ExePath)
+	assert.True(t, evt.ProcessFields.IsElevated)
+
+	// relative path is joined with cwd
+	evt = events[1].Event
+	assert.Equal(t, types.SimpleSchemaFileRead, evt.EventType)
+	assert.Equal(t, types.SimpleFileActionOpenRead, evt.FileFields.Action)
+	assert.Equal(t, "/etc/passwd", evt.FileFields.TargetPath)
+	assert.Equal(t, int64(1002), evt.FileFields.Pid)
+
+	evt = events[2].Event
+	assert.Equal(t, types.SimpleSchemaFilemod, evt.EventType)
+	assert.Equal(t, types.SimpleFileActionCreate, evt.FileFields.Action)
+	assert.Equal(t, "/tmp/out.txt", evt.FileFields.TargetPath)
+	assert.Equal(t, "/usr/bin/dash", evt.FileFields.ExePath)
+
+	// interleaved records of 103 and 104
+	evt = events[3].Event
+	assert.Equal(t, types.SimpleFileActionOpenWrite, evt.FileFields.Action)
+	assert.Equal(t, "/tmp/out.txt", evt.FileFields.TargetPath)
+
+	evt = events[4].Event
+	assert.Equal(t, types.SimpleFileActionRename, evt.FileFields.Action)
+	assert.Equal(t, "/tmp/out.txt", evt.FileFields.TargetPath)
+	assert.Equal(t, "/tmp/moved.txt", evt.FileFields.DestPath)
+
+	evt = events[5].Event
+	assert.Equal(t, types.SimpleFileActionDelete, evt.FileFields.Action)
+	assert.Equal(t, "/root/secret", evt.FileFields.TargetPath)
+	assert.Equal(t, int32(-13), evt.FileFields.ExitCode)
+
+	evt = events[6].Event
+	assert.Equal(t, types.SimpleFileActionChmod, evt.FileFields.Action)
+	assert.Equal(t, "755", evt.FileFields.PermFlags)
+
+	// aarch64 enriched, arg split in chunks
+	evt = events[7].Event
+	assert.Equal(t, types.SimpleSchemaProcess, evt.EventType)
+	assert.Equal(t, "sh -c echo hello", evt.ProcessFields.Cmdline)
+	assert.False(t, evt.ProcessFields.IsElevated)
+
+	assert.Contains(t, string(events[7].Raw), "\"serial\":107")
+}
+
+func TestConvertOpenat2(t *testing.T) {
+	convert := func(log string) *types.SimpleEvent {
+		var retval *types.SimpleEvent
+		assert.Nil(t, ReadAuditEvents(strings.NewReader(log), func(evt *AuditEvent) {
+			retval = ConvertAuditEvent(evt)
+		}))
+		return retval
+	}
+	syscall := "type=SYSCALL msg=audit(1700000000.100:100): arch=c000003e syscall=437 success=yes exit=3 a0=ffffff9c a1=7ffd5a2b a2=7ffd5a40 a3=18 items=1 ppid=1000 pid=1001 exe=\"/usr/bin/dash\"\n"
+	path := "type=PATH msg=audit(1700000000.100:100): item=0 name=\"/tmp/out.txt\" nametype=NORMAL\n"
+
+	// O_WRONLY|O_CREAT|O_TRUNC of existing file
+	evt := convert(syscall + "type=OPENAT2 msg=audit(1700000000.100:100): oflag=01101 mode=0644 resolve=0x0\n" + path)
+	assert.Equal(t, types.SimpleSchemaFilemod, evt.EventType)
+	assert.Equal(t, types.SimpleFileActionOpenWrite, evt.FileFields.Action)
+	assert.Equal(t, "/tmp/out.txt", evt.FileFields.TargetPath)
+
+	evt = convert(syscall + "type=OPENAT2 msg=audit(1700000000.100:100): oflag=00 mode=00 resolve=0x0\n" + path)
+	assert.Equal(t, types.SimpleSchemaFileRead, evt.EventType)
+
+	// flags not known without OPENAT2 record
+	assert.Nil(t, convert(syscall+path))
+	evt = convert(syscall + strings.Replace(path, "NORMAL", "CREATE", 1))
+	assert.Equal(t, types.SimpleFileActionCreate, evt.FileFields.Action)
+}
+
+func TestGetSyscallName(t *testing.T) {
+	assert.Equal(t, "openat", GetSyscallName(kAuditArchX86_64, 257))
+	assert.Equal(t, "openat", GetSyscallName(kAuditArchAarch64, 56))
+	assert.Equal(t, "", GetSyscallName("40000003", 5))
+}
+
+func TestFetch(t *testing.T) {
+	dir := t.TempDir()
+	err := Fetch([]string{"testdata/missing.log", "testdata/audit.log"}, dir, "_auditd", 0, 1<<62)
+	assert.Nil(t, err)
+
+	simple, err := os.ReadFile(filepath.Join(dir, "simple_telemetry_auditd.json"))
+	assert.Nil(t, err)
+	raw, err := os.ReadFile(filepath.Join(dir, "telemetry_auditd.json"))
+	assert.Nil(t, err)
+
+	simpleLines := strings.Split(strings.TrimSpace(string(simple)), "\n")
+	rawLines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	assert.Equal(t, 9, len(simpleLines))
+	assert.Equal(t, len(simpleLines), len(rawLines))
+
+	evt := &types.SimpleEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(simpleLines[8]), evt))
+	assert.Equal(t, "id", evt.ProcessFields.Cmdline)
+}
@@ -0,0 +1,173 @@
+package main
+
+/*
+ * telemtool-auditd is a telemetry tool for the harness that converts
+ * linux auditd audit.log records to the simple schema.  Process events
+ * come from execve syscalls, and file events from open, unlink, rename,
+ * chmod, etc. syscalls.  Audit rules need to be loaded for these,
+ * see README.md.
+ */
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+)
+
+var flagPrepare bool
+var flagFetch bool
+var flagClearCache bool
+var flagResultsDir string
+var flagSuffix string
+var flagTimeRange string
+var flagAuditLogPaths string
+var flagVerbose bool
+
+func init() {
+	flag.BoolVar(&flagPrepare, "prepare", false, "check that audit logs are readable")
+	flag.BoolVar(&flagFetch, "fetch", false, "convert audit events in time range and write to resultsdir")
+	flag.BoolVar(&flagClearCache, "clearcache", false, "ignored, there is no cache")
+	flag.StringVar(&flagResultsDir, "resultsdir", "", "harness results dir")
+	flag.StringVar(&flagSuffix, "suffix", "", "suffix of telemetry file names")
+	flag.StringVar(&flagTimeRange, "ts", "", "start,end unix time in seconds. Only events in range are written")
+	flag.StringVar(&flagAuditLogPaths, "auditlog", "/var/log/audit/audit.log.1,/var/log/audit/audit.log", "comma-separated paths of audit logs, oldest first. Missing files are ignored")
+	flag.BoolVar(&flagVerbose, "verbose", false, "print more details")
+}
+
+type ConvertedEvent struct {
+	Event *types.SimpleEvent
+	Raw   []byte
+}
+
+/*
+ * ConvertAuditLog returns the simple events of audit log at path in
+ * time range.  The native event is the JSON of audit records.
+ */
+func ConvertAuditLog(path string, start, end int64) ([]*ConvertedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	retval := []*ConvertedEvent{}
+	err = ReadAuditEvents(f, func(evt *AuditEvent) {
+		if evt.Timestamp < start || evt.Timestamp > end {
+			return
+		}
+		simple := ConvertAuditEvent(evt)
+		if simple == nil {
+			return
+		}
+		native := struct {
+			Serial  uint64   `json:"serial"`
+			Records []string `json:"records"`
+		}{Serial: evt.Serial}
+		for _, rec := range evt.Records {
+			native.Records = append(native.Records, rec.Line)
+		}
+		raw, err := json.Marshal(native)
+		if err != nil {
+			fmt.Println("ERROR: encoding audit records", err)
+			return
+		}
+		retval = append(retval, &ConvertedEvent{Event: simple, Raw: raw})
+	})
+	return retval, err
+}
+
+/*
+ * Fetch writes the simple_telemetry and telemetry files in resultsDir
+ * with events of audit logs in time range.
+ */
+func Fetch(auditLogPaths []string, resultsDir, suffix string, start, end int64) error {
+	events := []*ConvertedEvent{}
+	for _, path := range auditLogPaths {
+		a, err := ConvertAuditLog(path, start, end)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if flagVerbose {
+			fmt.Println("converted", len(a), "events from", path)
+		}
+		events = append(events, a...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Event.Timestamp < events[j].Event.Timestamp
+	})
+
+	simpleLines := [][]byte{}
+	rawLines := [][]byte{}
+	for _, evt := range events {
+		j, err := json.Marshal(evt.Event)
+		if err != nil {
+			fmt.Println("ERROR: encoding event", err)
+			continue
+		}
+		simpleLines = append(simpleLines, j)
+		rawLines = append(rawLines, evt.Raw)
+	}
+	err := utils.WriteTelemetryFiles(resultsDir, suffix, simpleLines, rawLines)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("wrote", len(simpleLines), "events")
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	flagSuffix = utils.TelemToolSuffix(flagSuffix)
+	auditLogPaths := strings.Split(flagAuditLogPaths, ",")
+
+	if flagPrepare {
+		numReadable := 0
+		for _, path := range auditLogPaths {
+			f, err := os.Open(path)
+			if err == nil {
+				numReadable += 1
+				f.Close()
+			} else if !os.IsNotExist(err) {
+				fmt.Println("WARN: unable to read", path, err)
+			}
+		}
+		if numReadable == 0 {
+			fmt.Println("ERROR: no readable audit logs", flagAuditLogPaths)
+			os.Exit(int(types.StatusTelemetryToolFailure))
+		}
+		os.Exit(0)
+	}
+
+	if !flagFetch {
+		fmt.Println("ERROR: specify --prepare or --fetch")
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+	if len(flagResultsDir) == 0 {
+		fmt.Println("ERROR: --resultsdir is required")
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	start, end, err := utils.ParseTimeRange(flagTimeRange)
+	if err != nil {
+		fmt.Println("ERROR: invalid --ts", err)
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	err = Fetch(auditLogPaths, flagResultsDir, flagSuffix, start, end)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(int(types.StatusTelemetryToolFailure))
+	}
+}
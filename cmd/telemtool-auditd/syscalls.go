@@ -0,0 +1,87 @@
+package main
+
+/*
+ * syscall names by number, for audit records without the enriched
+ * SYSCALL= name.  Only the syscalls converted to simple events.
+ */
+
+const (
+	kAuditArchX86_64  = "c000003e"
+	kAuditArchAarch64 = "c00000b7"
+)
+
+var gSyscallsX86_64 = map[int]string{
+	2:   "open",
+	59:  "execve",
+	76:  "truncate",
+	77:  "ftruncate",
+	82:  "rename",
+	83:  "mkdir",
+	84:  "rmdir",
+	85:  "creat",
+	86:  "link",
+	87:  "unlink",
+	88:  "symlink",
+	90:  "chmod",
+	91:  "fchmod",
+	92:  "chown",
+	93:  "fchown",
+	94:  "lchown",
+	188: "setxattr",
+	189: "lsetxattr",
+	190: "fsetxattr",
+	197: "removexattr",
+	198: "lremovexattr",
+	199: "fremovexattr",
+	257: "openat",
+	258: "mkdirat",
+	260: "fchownat",
+	263: "unlinkat",
+	264: "renameat",
+	265: "linkat",
+	266: "symlinkat",
+	268: "fchmodat",
+	316: "renameat2",
+	322: "execveat",
+	437: "openat2",
+}
+
+// asm-generic numbers
+var gSyscallsAarch64 = map[int]string{
+	5:   "setxattr",
+	6:   "lsetxattr",
+	7:   "fsetxattr",
+	14:  "removexattr",
+	15:  "lremovexattr",
+	16:  "fremovexattr",
+	34:  "mkdirat",
+	35:  "unlinkat",
+	36:  "symlinkat",
+	37:  "linkat",
+	38:  "renameat",
+	45:  "truncate",
+	46:  "ftruncate",
+	52:  "fchmod",
+	53:  "fchmodat",
+	54:  "fchownat",
+	55:  "fchown",
+	56:  "openat",
+	221: "execve",
+	276: "renameat2",
+	281: "execveat",
+	437: "openat2",
+}
+
+/*
+ * GetSyscallName returns the name of syscall number for audit arch,
+ * or empty string if not known.
+ */
+func GetSyscallName(arch string, num int) string {
+	switch arch {
+	case kAuditArchX86_64:
+		return gSyscallsX86_64[num]
+	case kAuditArchAarch64:
+		return gSyscallsAarch64[num]
+	}
+	return ""
+}
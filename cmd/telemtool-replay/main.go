@@ -0,0 +1,254 @@
+package main
+
+/*
+ * telemtool-replay is a telemetry tool for the harness that serves
+ * pre-recorded simple schema events, rather than fetching them from an
+ * endpoint agent.  Used to exercise the harness offline and in CI.
+ *
+ * Fixtures are folders named the same as the results dir of a test,
+ * e.g. T1560.002_3, containing:
+ *   run_summary.json      - from goartrun run that was recorded
+ *   simple_telemetry.json - events of recorded run, one per line
+ *   telemetry.json        - optional native events, one per line
+ *
+ * On fetch, the timestamps of events are shifted by the difference in
+ * StartTime of the recorded and current run_summary.json of the test,
+ * and the recorded TempDir and ResultsDir are replaced with current.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+	utils "github.com/secureworks/atomic-harness/pkg/utils"
+)
+
+var flagPrepare bool
+var flagFetch bool
+var flagClearCache bool
+var flagResultsDir string
+var flagSuffix string
+var flagTimeRange string
+var flagFixturesPath string
+var flagVerbose bool
+
+func init() {
+	flag.BoolVar(&flagPrepare, "prepare", false, "prepare for a run of the harness")
+	flag.BoolVar(&flagFetch, "fetch", false, "write telemetry of tests in resultsdir")
+	flag.BoolVar(&flagClearCache, "clearcache", false, "ignored, there is no cache")
+	flag.StringVar(&flagResultsDir, "resultsdir", "", "harness results dir")
+	flag.StringVar(&flagSuffix, "suffix", "", "suffix of telemetry file names")
+	flag.StringVar(&flagTimeRange, "ts", "", "start,end unix time in seconds. Only events in range are written")
+	flag.StringVar(&flagFixturesPath, "fixtures", os.Getenv("TELEMTOOL_REPLAY_FIXTURES"), "path to folder of recorded test telemetry. Defaults to env TELEMTOOL_REPLAY_FIXTURES")
+	flag.BoolVar(&flagVerbose, "verbose", false, "print more details")
+}
+
+type ReplayEvent struct {
+	Event  *types.SimpleEvent
+	Simple []byte
+	Raw    []byte
+}
+
+func ReadLines(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := [][]byte{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+/*
+ * jsonEscape returns s as it would appear inside a JSON string
+ */
+func jsonEscape(s string) string {
+	j, _ := json.Marshal(s)
+	return string(j[1 : len(j)-1])
+}
+
+/*
+ * LoadFixture returns the recorded events of fixtureDir, adjusted to
+ * the run of the test in resultsDir.
+ */
+func LoadFixture(fixtureDir string, resultsDir string) ([]*ReplayEvent, error) {
+	recorded := &types.ScriptResults{}
+	err := utils.LoadRunSummary(fixtureDir, recorded)
+	if err != nil {
+		return nil, err
+	}
+	current := &types.ScriptResults{}
+	err = utils.LoadRunSummary(resultsDir, current)
+	if err != nil {
+		return nil, err
+	}
+
+	shift := int64(0)
+	if recorded.StartTime != 0 && current.StartTime != 0 {
+		shift = current.StartTime - recorded.StartTime
+	}
+
+	pairs := []string{}
+	if len(recorded.Spec.TempDir) > 0 && len(current.Spec.TempDir) > 0 {
+		pairs = append(pairs, jsonEscape(recorded.Spec.TempDir), jsonEscape(current.Spec.TempDir))
+	}
+	if len(recorded.Spec.ResultsDir) > 0 && len(current.Spec.ResultsDir) > 0 {
+		pairs = append(pairs, jsonEscape(recorded.Spec.ResultsDir), jsonEscape(current.Spec.ResultsDir))
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	simpleLines, err := ReadLines(filepath.Join(fixtureDir, "simple_telemetry.json"))
+	if err != nil {
+		return nil, err
+	}
+	rawLines, err := ReadLines(filepath.Join(fixtureDir, "telemetry.json"))
+	if err != nil || len(rawLines) != len(simpleLines) {
+		if flagVerbose {
+			fmt.Println("using simple events as native events for", fixtureDir)
+		}
+		rawLines = simpleLines
+	}
+
+	retval := []*ReplayEvent{}
+	for i, line := range simpleLines {
+		evt := &types.SimpleEvent{}
+		err = json.Unmarshal([]byte(replacer.Replace(string(line))), evt)
+		if err != nil {
+			fmt.Println("ERROR: parsing event", err, string(line))
+			continue
+		}
+		if evt.Timestamp != 0 {
+			evt.Timestamp += shift
+		}
+		if evt.IngestTimestamp != 0 {
+			evt.IngestTimestamp += shift
+		}
+		simple, err := json.Marshal(evt)
+		if err != nil {
+			fmt.Println("ERROR: encoding event", err)
+			continue
+		}
+		raw := []byte(replacer.Replace(string(rawLines[i])))
+		retval = append(retval, &ReplayEvent{Event: evt, Simple: simple, Raw: raw})
+	}
+	return retval, nil
+}
+
+/*
+ * Fetch writes simple_telemetry and telemetry files in resultsDir with
+ * the events of fixtures for each test that ran, in the time range.
+ * resultsDir may also be the results dir of a single test.
+ */
+func Fetch(resultsDir, fixturesDir, suffix string, start, end int64) error {
+	testNames := []string{}
+	testDirs := []string{}
+	if _, err := os.Stat(filepath.Join(resultsDir, "run_summary.json")); err == nil {
+		// results dir of a single test, harness --fetchmode test
+		testNames = append(testNames, filepath.Base(resultsDir))
+		testDirs = append(testDirs, resultsDir)
+	} else {
+		entries, err := os.ReadDir(resultsDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				testNames = append(testNames, entry.Name())
+				testDirs = append(testDirs, filepath.Join(resultsDir, entry.Name()))
+			}
+		}
+	}
+
+	events := []*ReplayEvent{}
+	for i, name := range testNames {
+		testResultsDir := testDirs[i]
+		if _, err := os.Stat(filepath.Join(testResultsDir, "run_summary.json")); err != nil {
+			continue // test did not run
+		}
+		fixtureDir := filepath.Join(fixturesDir, name)
+		if _, err := os.Stat(fixtureDir); err != nil {
+			fmt.Println("no fixture for", name)
+			continue
+		}
+
+		a, err := LoadFixture(fixtureDir, testResultsDir)
+		if err != nil {
+			fmt.Println("ERROR: loading fixture", fixtureDir, err)
+			continue
+		}
+		for _, evt := range a {
+			ts := evt.Event.Timestamp
+			if ts != 0 && (ts < start || ts > end) {
+				continue
+			}
+			events = append(events, evt)
+		}
+		if flagVerbose {
+			fmt.Println("loaded", len(a), "events for", name)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Event.Timestamp < events[j].Event.Timestamp
+	})
+
+	simpleLines := [][]byte{}
+	rawLines := [][]byte{}
+	for _, evt := range events {
+		simpleLines = append(simpleLines, evt.Simple)
+		rawLines = append(rawLines, evt.Raw)
+	}
+	err := utils.WriteTelemetryFiles(resultsDir, suffix, simpleLines, rawLines)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("wrote", len(events), "events")
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	flagSuffix = utils.TelemToolSuffix(flagSuffix)
+
+	if flagPrepare {
+		if len(flagFixturesPath) == 0 {
+			fmt.Println("WARN: no --fixtures path or TELEMTOOL_REPLAY_FIXTURES")
+		}
+		os.Exit(0)
+	}
+
+	if !flagFetch {
+		fmt.Println("ERROR: specify --prepare or --fetch")
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+	if len(flagResultsDir) == 0 || len(flagFixturesPath) == 0 {
+		fmt.Println("ERROR: --resultsdir and --fixtures are required")
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	start, end, err := utils.ParseTimeRange(flagTimeRange)
+	if err != nil {
+		fmt.Println("ERROR: invalid --ts", err)
+		os.Exit(int(types.StatusInvalidArguments))
+	}
+
+	err = Fetch(flagResultsDir, flagFixturesPath, flagSuffix, start, end)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(int(types.StatusTelemetryToolFailure))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRunSummary(t *testing.T, dir string, startTime int64, tempDir string) {
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	obj := types.ScriptResults{StartTime: startTime}
+	obj.Spec.TempDir = tempDir
+	j, err := json.Marshal(obj)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "run_summary.json"), j, 0644))
+}
+
+func TestFetch(t *testing.T) {
+	fixtures := t.TempDir()
+	resultsDir := t.TempDir()
+
+	recorded := int64(1000 * time.Second)
+	writeRunSummary(t, filepath.Join(fixtures, "T1000_1"), recorded, "/tmp/artwork-T1000_1-1")
+	events := strings.Join([]string{
+		`{"evt_type":"P","ts":1000000000001,"evt_process":{"cmdline":"sh /tmp/artwork-T1000_1-1/goart-T1000-test.sh","pid":10}}`,
+		`{"evt_type":"P","ts":1010000000000,"evt_process":{"cmdline":"too late","pid":11}}`,
+		`{"evt_type":"P","evt_process":{"cmdline":"no ts","pid":12}}`,
+	}, "\n")
+	assert.Nil(t, os.WriteFile(filepath.Join(fixtures, "T1000_1", "simple_telemetry.json"), []byte(events), 0644))
+
+	// T1000_2 did not run, T1000_3 has no fixture
+	assert.Nil(t, os.MkdirAll(filepath.Join(fixtures, "T1000_2"), 0755))
+	current := int64(5000 * time.Second)
+	writeRunSummary(t, filepath.Join(resultsDir, "T1000_1"), current, "/tmp/artwork-T1000_1-2")
+	writeRunSummary(t, filepath.Join(resultsDir, "T1000_3"), current, "/tmp/artwork-T1000_3-3")
+
+	err := Fetch(resultsDir, fixtures, "_x", current, current+int64(5*time.Second))
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filepath.Join(resultsDir, "simple_telemetry_x.json"))
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	// events without ts are sorted first
+	evt := &types.SimpleEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), evt))
+	assert.Equal(t, current+1, evt.Timestamp)
+	assert.Equal(t, "sh /tmp/artwork-T1000_1-2/goart-T1000-test.sh", evt.ProcessFields.Cmdline)
+
+	// without telemetry.json in fixture, simple events are native events
+	raw, err := os.ReadFile(filepath.Join(resultsDir, "telemetry_x.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(raw), "\n"))
+	assert.Contains(t, string(raw), "/tmp/artwork-T1000_1-2/")
+}
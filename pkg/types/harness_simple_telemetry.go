@@ -36,14 +36,15 @@ type SimpleProcessFields struct {
 	IsElevated bool   `json:"is_elevated,omitempty"`
 	Hashes     string `json:"hashes,omitempty"`
 
-	UniquePid       string `json:"unique_pid",omitempty`
+	UniquePid       string `json:"unique_pid,omitempty"`
 	ParentUniquePid string `json:"parent_unique_pid,omitempty"`
 	ChainId         string `json:"chainid,omitempty"` // processes piped together have same chainid
 }
 
 type SimpleProcessExitFields struct {
-	ExitCode int32 `json:"exit_code"`
-	Pid      int64 `json:"pid"` // required
+	ExitCode  int32  `json:"exit_code"`
+	Pid       int64  `json:"pid"` // required
+	UniquePid string `json:"unique_pid,omitempty"`
 }
 
 type SimpleFileAction string
@@ -83,6 +84,76 @@ type SimpleNetflowFields struct {
 	ExePath   string `json:"exe_path,omitempty"`
 }
 
+type SimpleAuthFields struct {
+	Action         string `json:"action"`                    // LOGIN, LOGOUT, SU, SUDO, PASSWD, USERADD, ...
+	IsSuccess      bool   `json:"is_success"`                // false for failed logins, etc.
+	Username       string `json:"username,omitempty"`        // user authenticating
+	TargetUsername string `json:"target_username,omitempty"` // if different, for su, sudo, passwd
+	Service        string `json:"service,omitempty"`         // sshd, login, PAM service name
+	RemoteAddr     string `json:"remote_addr,omitempty"`     // if remote login
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
+// kernel module or shared library load
+type SimpleModuleFields struct {
+	Action string `json:"action"` // LOAD, UNLOAD
+	Name   string `json:"name"`   // required
+	Path   string `json:"path,omitempty"`
+	Hashes string `json:"hashes,omitempty"`
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
+type SimpleVolumeFields struct {
+	Action     string `json:"action"`                // MOUNT, UNMOUNT, REMOUNT
+	DevicePath string `json:"device_path,omitempty"` // e.g. /dev/sdb1, tmpfs
+	MountPath  string `json:"mount_path,omitempty"`
+	FsType     string `json:"fs_type,omitempty"`
+	Options    string `json:"options,omitempty"` // e.g. "ro,noexec"
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
+type SimplePTraceFields struct {
+	Request       string `json:"request"` // ATTACH, SEIZE, PEEKDATA, POKETEXT, ...
+	TargetPid     int64  `json:"target_pid"`
+	TargetExePath string `json:"target_exe_path,omitempty"`
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
+// raw socket or promiscuous mode used to capture network traffic
+type SimpleNetsniffFields struct {
+	Action    string `json:"action"`              // RAW_SOCKET, PROMISC
+	Protocol  string `json:"protocol,omitempty"`  // e.g. AF_PACKET
+	Interface string `json:"interface,omitempty"` // e.g. eth0
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
+// alert from endpoint agent. mitre_techniques of event holds tags
+type SimpleDetectionFields struct {
+	RuleName string `json:"rule_name"` // required
+	RuleId   string `json:"rule_id,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message,omitempty"`
+
+	Pid       int64  `json:"pid,omitempty"`
+	UniquePid string `json:"unique_pid,omitempty"`
+	ExePath   string `json:"exe_path,omitempty"`
+}
+
 type SimpleETWFields struct {
 	ChanName string `json:"chan_name,omitempty"`       // chan_name: "Microsoft-Windows-PowerShell/Operational "
 	EventMsg string `json:"event_msg,omitempty"`       // event_msg: "Creating Scriptblock text (%1 of %2): .... "
@@ -117,7 +188,8 @@ type SimpleAPIFields struct {
 type SimpleEvent struct {
 	EventType       SimpleSchemaChar `json:"evt_type"`
 	Timestamp       int64            `json:"ts,omitempty"`
-	TimeStr         string           `json:"ts_str,omitempty"` // only need ts or ts_str
+	TimeStr         string           `json:"ts_str,omitempty"`    // only need ts or ts_str
+	IngestTimestamp int64            `json:"ingest_ts,omitempty"` // when the event reached the backend, if known
 	MitreTechniques []string         `json:"mitre_techniques,omitempty"`
 
 	ProcessFields     *SimpleProcessFields     `json:"evt_process,omitempty"`
@@ -128,4 +200,10 @@ type SimpleEvent struct {
 	AMSIFields        *SimpleAMSIFields        `json:"evt_amsi,omitempty"`
 	RegFields         *SimpleRegFields         `json:"evt_reg,omitempty"`
 	APIFields         *SimpleAPIFields         `json:"evt_api,omitempty"`
+	AuthFields        *SimpleAuthFields        `json:"evt_auth,omitempty"`
+	ModuleFields      *SimpleModuleFields      `json:"evt_module,omitempty"`
+	VolumeFields      *SimpleVolumeFields      `json:"evt_volume,omitempty"`
+	PTraceFields      *SimplePTraceFields      `json:"evt_ptrace,omitempty"`
+	NetsniffFields    *SimpleNetsniffFields    `json:"evt_netsniff,omitempty"`
+	DetectionFields   *SimpleDetectionFields   `json:"evt_detection,omitempty"`
 }
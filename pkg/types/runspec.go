@@ -66,6 +66,7 @@ const (
 	StatusValidatePartial                 // 12
 	StatusValidateSuccess                 // 13
 	StatusDelegateValidation              // 14
+	StatusValidateUnexpected              // 15 a negated (_N_) event was seen
 )
 
 // keeping these names at 4-character for status text align
@@ -82,9 +83,10 @@ func (s TestState) String() string {
 func (s TestStatus) String() string {
 	strings := [...]string{"Unknown", "MiscError", "NoAtomic", "NoCriteria",
 		"Skipped", "InvalidArgs", "RunnerFail", "PreReqFail",
-		"TestFail", "TestRan", "ToolFail", "NoTelemetry", "Partial", "Validated", "Ready2Eval"}
+		"TestFail", "TestRan", "ToolFail", "NoTelemetry", "Partial", "Validated", "Ready2Eval",
+		"Unexpected"}
 
-	if s < StatusUnknown || s > StatusDelegateValidation {
+	if s < StatusUnknown || s > StatusValidateUnexpected {
 		return "Unknown"
 	}
 
@@ -115,4 +117,11 @@ type TestProgress struct {
 	State    TestState
 	ExitCode int
 	Status   TestStatus
+
+	MatchString      string                `json:",omitempty"`
+	ToolStatus       map[string]TestStatus `json:",omitempty"` // by telemetry tool, when more than one
+	ToolMatchStrings map[string]string     `json:",omitempty"`
+
+	NumAlerts         uint64 `json:",omitempty"` // _A_ rows of criteria
+	NumAlertsDetected uint64 `json:",omitempty"`
 }
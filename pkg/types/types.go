@@ -2,42 +2,60 @@ package types
 
 import (
 	"fmt"
+	"regexp"
 )
 
 // from CSV
 
+// cmdline~=whoami, path:i$=.EXE, dst_port<1024, dst_port@=22,2222, dst_ip<<=10.0.0.0/8,::1
 type FieldCriteria struct {
-	FieldName string `json:"field"`
-	Op        string `json:"op"`
-	Value     string `json:"value"`
+	FieldName  string `json:"field"`
+	Op         string `json:"op"`
+	Value      string `json:"value"`
+	IgnoreCase bool   `json:"ignore_case,omitempty"`
+
+	Regex *regexp.Regexp `json:"-"` // for *= and %= ops, see utils.CompileFieldCriteria
 }
 
 // _E_,Process,cmdline=echo "# THIS IS A COMMENT"
 // _E_,File,WRITE,path=/etc/ufw/ufw.conf
+// _N_,File,READ,path=/tmp/cleanup_only.txt  (must NOT be seen)
 type ExpectedEvent struct {
 	Id          string          `json:"id"`
 	EventType   string          `json:"event_type"`
 	SubType     string          `json:"sub_type,omitempty"`
 	FieldChecks []FieldCriteria `json:"field_checks"`
 	IsMaybe     bool            `json:"is_maybe,omitempty"`
+	IsNegated   bool            `json:"is_negated,omitempty"`
 
-	Matches []*SimpleEvent `json:"matches,omitempty"`
+	SubTypeRegex *regexp.Regexp `json:"-"` // for '*' wildcards, see utils.CompileSubType
+
+	Matches            []*SimpleEvent `json:"matches,omitempty"`
+	TimeToFirstMatchMs int64          `json:"time_to_first_match_ms,omitempty"` // from event ts until fetched, when polling
 }
 
 // _C_,Process,Pipe,0,1
+// _C_,Sequence,Before,0,1,2,maxgap=5s
 type CorrelationRow struct {
 	Id           string   `json:"id"`
 	Type         string   `json:"type"`
 	SubType      string   `json:"sub_type"`
 	EventIndexes []string `json:"indexes"`
+	MaxGapNs     int64    `json:"max_gap_ns,omitempty"` // Sequence: max time between consecutive events
 	IsMet        bool     `json:"is_met"`
 }
 
 // _A_,Process,exit elevated
 // _A_,Process,high_cpu
+// _A_,Process,severity=high,rule_name~=Credential
+// _A_,Technique  (any detection tagged with technique of test)
 type AlertRow struct {
-	Type    string
-	Matches []string
+	Id          string          `json:"id"`
+	Type        string          `json:"type"`
+	Keywords    []string        `json:"keywords,omitempty"` // in rule_name, rule_id or message
+	FieldChecks []FieldCriteria `json:"field_checks,omitempty"`
+
+	Matches []*SimpleEvent `json:"matches,omitempty"`
 }
 
 // ARG,remote_host,victim-host
@@ -54,6 +72,7 @@ type MitreTestCriteria struct {
 
 	ExpectedEvents       []*ExpectedEvent  `json:"expected_events"`
 	ExpectedCorrelations []*CorrelationRow `json:"exp_correlations,omitempty"`
+	ExpectedAlerts       []*AlertRow       `json:"exp_alerts,omitempty"`
 }
 
 // T1562.004,linux,7,Stop/Start UFW firewall
@@ -63,8 +82,6 @@ type AtomicTestCriteria struct {
 	Args     map[string]string `json:"args,omitempty"`
 	Infos    []string          `json:"infos,omitempty"`    // FYI
 	Warnings []string          `json:"warnings,omitempty"` // !!!
-
-	ExpectedCorrelations []CorrelationRow `json:"exp_correlations,omitempty"`
 }
 
 func (s *AtomicTestCriteria) Id() string {
@@ -7,7 +7,6 @@ package utils
 import (
 	"bytes"
 	"encoding/csv"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -15,6 +14,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -41,42 +41,11 @@ func AtomicTestCriteriaNew(tid string, plat string, numstr string, name string)
 	return obj
 }
 
-func ParseFieldCriteria(str string, eventType string) (*types.FieldCriteria, error) {
-	a := strings.SplitN(str, "=", 2)
-	if len(a) != 2 {
-		if eventType == "FILE" {
-			// assume it's a path
-			a = []string{"path", str}
-		} else {
-			return nil, errors.New("no operator")
-		}
-	}
-	fc := &types.FieldCriteria{}
-	fc.FieldName = a[0]
-	fc.Value = a[1]
-	namelen := len(fc.FieldName)
-
-	switch fc.FieldName[namelen-1] {
-	case '*':
-		fc.Op = "*="
-		namelen -= 1
-	case '~':
-		fc.Op = "~="
-		namelen -= 1
-	case '!':
-		fc.Op = "!="
-		namelen -= 1
-	default:
-		fc.Op = "="
-	}
-
-	// TODO: trim whitespace on name, value
-	fc.FieldName = fc.FieldName[:namelen]
-
-	return fc, nil
-}
-
-func EventFromRow(id int, row []string) types.ExpectedEvent {
+/*
+ * EventFromRow parses _E_, _?_ and _N_ rows.  Field checks that are
+ * invalid are left out, and returned in error.
+ */
+func EventFromRow(id int, row []string) (types.ExpectedEvent, error) {
 	obj := types.ExpectedEvent{}
 	obj.Id = fmt.Sprintf("%d",id)
 	obj.EventType = row[1] //strings.ToTitle(strings.ToLower(row[1]))
@@ -86,7 +55,7 @@ func EventFromRow(id int, row []string) types.ExpectedEvent {
 		obj.SubType = row[2] // TODO: can have multiple CREATE|WRITE
 		idx += 1
 	}
-	if ET == "NETFLOW" {
+	if ET == "NETFLOW" && len(row) > 2 && !IsFieldCriteria(row[2]) {
 		obj.SubType = row[2] // TCP:*->victim-host:22
 		idx += 1
 	}
@@ -94,25 +63,133 @@ func EventFromRow(id int, row []string) types.ExpectedEvent {
 		obj.SubType = row[2] //
 		idx += 1
 	}
+	errs := []string{}
 	for i := idx; i < len(row); i++ {
 		entry, err := ParseFieldCriteria(row[i], ET)
 		if err != nil {
-			fmt.Println("ERROR: invalid FieldCriteria:"+row[i], err)
+			errs = append(errs, fmt.Sprintf("invalid FieldCriteria '%s': %v", row[i], err))
 			continue
 		}
 		obj.FieldChecks = append(obj.FieldChecks, *entry)
 	}
-	return obj
+	if err := CompileSubType(&obj); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return obj, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return obj, nil
 }
 
-func CorrelationFromRow(row []string) types.CorrelationRow {
+// _C_ Type,SubType supported by harness
+var kCorrelationTypes = map[string]bool{
+	"PROCESS,PIPE":    true,
+	"PROCESS,CHILD":   true,
+	"SEQUENCE,BEFORE": true,
+	"SEQUENCE,AFTER":  true,
+}
+
+/*
+ * CorrelationFromRow parses _C_ row.  Event indexes may be followed by
+ * maxgap=<duration>, e.g. "5s" or "500ms", or a number of seconds.
+ * Returns an error if there are fewer than 2 event indexes, an index is
+ * not a number, or the Type and SubType are not supported.  Indexes are
+ * checked against expected events by CheckCorrelationIndexes().
+ */
+func CorrelationFromRow(id int, row []string) (types.CorrelationRow, error) {
 	obj := types.CorrelationRow{}
+	obj.Id = fmt.Sprintf("%d", id)
+	if len(row) < 3 {
+		return obj, fmt.Errorf("expected type, subtype and at least 2 event indexes for _C_ row %v", row)
+	}
 	obj.Type = row[1]
 	obj.SubType = row[2]
+	if !kCorrelationTypes[strings.ToUpper(obj.Type)+","+strings.ToUpper(obj.SubType)] {
+		return obj, fmt.Errorf("unsupported correlation %s,%s", obj.Type, obj.SubType)
+	}
 	for i := 3; i < len(row); i++ {
-		obj.EventIndexes = append(obj.EventIndexes, row[i])
+		item := strings.TrimSpace(row[i])
+		if strings.HasPrefix(item, "maxgap=") {
+			gap, err := ParseMaxGap(strings.TrimPrefix(item, "maxgap="))
+			if err != nil {
+				return obj, err
+			}
+			obj.MaxGapNs = gap
+			continue
+		}
+		if index, err := strconv.Atoi(item); err != nil || index < 0 {
+			return obj, fmt.Errorf("invalid event index '%s' for _C_ row %v", item, row)
+		}
+		obj.EventIndexes = append(obj.EventIndexes, item)
 	}
-	return obj
+	if len(obj.EventIndexes) < 2 {
+		return obj, fmt.Errorf("expected at least 2 event indexes for _C_ row %v", row)
+	}
+	return obj, nil
+}
+
+/*
+ * CheckCorrelationIndexes returns an error if a correlation of criteria
+ * references an expected event that does not exist.
+ */
+func CheckCorrelationIndexes(criteria *types.AtomicTestCriteria) error {
+	for _, corr := range criteria.ExpectedCorrelations {
+		for _, item := range corr.EventIndexes {
+			if index, _ := strconv.Atoi(item); index >= len(criteria.ExpectedEvents) {
+				return fmt.Errorf("_C_ row %s,%s references unknown expected event %s", corr.Type, corr.SubType, item)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * ParseMaxGap parses maxgap of _C_ row, a duration or number of seconds,
+ * which must be positive.
+ */
+func ParseMaxGap(s string) (int64, error) {
+	gap := int64(0)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		gap = int64(secs * float64(time.Second))
+	} else if d, err := time.ParseDuration(s); err == nil {
+		gap = int64(d)
+	}
+	if gap <= 0 {
+		return 0, fmt.Errorf("invalid maxgap '%s'", s)
+	}
+	return gap, nil
+}
+
+/*
+ * AlertFromRow parses _A_ row.  Columns after type with an operator
+ * are field checks, others are keywords.  Invalid field checks are left
+ * out, and returned in error.
+ */
+func AlertFromRow(id int, row []string) (types.AlertRow, error) {
+	obj := types.AlertRow{}
+	obj.Id = fmt.Sprintf("%d", id)
+	obj.Type = row[1]
+	errs := []string{}
+	for i := 2; i < len(row); i++ {
+		item := strings.TrimSpace(row[i])
+		if len(item) == 0 {
+			continue
+		}
+		if !IsFieldCriteria(item) {
+			obj.Keywords = append(obj.Keywords, item)
+			continue
+		}
+		entry, err := ParseFieldCriteria(item, "ALERT")
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid FieldCriteria '%s': %v", item, err))
+			continue
+		}
+		obj.FieldChecks = append(obj.FieldChecks, *entry)
+	}
+	if len(errs) > 0 {
+		return obj, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return obj, nil
 }
 
 /*
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationFromRow(t *testing.T) {
+	tests := []struct {
+		row      string
+		indexes  []string
+		maxGapNs int64
+		isError  bool
+	}{
+		{"_C_,Process,Pipe,0,1", []string{"0", "1"}, 0, false},
+		{"_C_,process,child, 0 , 2", []string{"0", "2"}, 0, false},
+		{"_C_,Sequence,Before,0,1,2,maxgap=5s", []string{"0", "1", "2"}, int64(5 * time.Second), false},
+		{"_C_,Sequence,After,1,0,maxgap=0.5", []string{"1", "0"}, int64(500 * time.Millisecond), false},
+		{"_C_,Sequence,Before,0,maxgap=5s", nil, 0, true},
+		{"_C_,Sequence,Before,0", nil, 0, true},
+		{"_C_,Sequence", nil, 0, true},
+		{"_C_,Sequence,Before,0,one", nil, 0, true},
+		{"_C_,Sequence,Before,0,-1", nil, 0, true},
+		{"_C_,Sequence,Before,0,1,maxgap=0", nil, 0, true},
+		{"_C_,Sequence,Before,0,1,maxgap=-5", nil, 0, true},
+		{"_C_,Sequence,Before,0,1,maxgap=-5s", nil, 0, true},
+		{"_C_,Sequence,Before,0,1,maxgap=soon", nil, 0, true},
+		{"_C_,Sequence,During,0,1", nil, 0, true},
+		{"_C_,File,Pipe,0,1", nil, 0, true},
+	}
+	for _, tt := range tests {
+		corr, err := CorrelationFromRow(0, strings.Split(tt.row, ","))
+		if tt.isError {
+			assert.NotNil(t, err, tt.row)
+			continue
+		}
+		assert.Nil(t, err, tt.row)
+		assert.Equal(t, tt.indexes, corr.EventIndexes, tt.row)
+		assert.Equal(t, tt.maxGapNs, corr.MaxGapNs, tt.row)
+	}
+}
+
+func TestCheckCorrelationIndexes(t *testing.T) {
+	criteria := &types.AtomicTestCriteria{}
+	criteria.ExpectedEvents = []*types.ExpectedEvent{{Id: "0"}, {Id: "1"}}
+	criteria.ExpectedCorrelations = []*types.CorrelationRow{{Type: "Process", SubType: "Pipe", EventIndexes: []string{"0", "1"}}}
+	assert.Nil(t, CheckCorrelationIndexes(criteria))
+
+	criteria.ExpectedCorrelations[0].EventIndexes = []string{"0", "2"}
+	assert.NotNil(t, CheckCorrelationIndexes(criteria))
+}
@@ -0,0 +1,282 @@
+package utils
+
+/*
+ * Field check operators of validation criteria
+ *
+ *   =   equals            !=  not equals
+ *   ~=  contains          *=  regex
+ *   ^=  starts with       $=  ends with
+ *   %=  shell glob        @=  in comma separated list
+ *   <<= IP address in comma separated list of CIDRs or addresses
+ *   <  >  <=  >=          numeric comparison
+ *
+ * Adding ':i' after the field name makes the check case-insensitive,
+ * e.g. cmdline:i~=WHOAMI
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+var gRxFieldCriteria = regexp.MustCompile(`^([A-Za-z_][\w.]*)(:i)?(!=|~=|\*=|\^=|\$=|%=|@=|<<=|<=|>=|<|>|=)(.*)$`)
+
+// criteria variables are substituted before tests run, e.g. #{remote_host}
+var gRxCriteriaVar = regexp.MustCompile(`#{.*}`)
+
+/*
+ * GlobToRegex returns regex for shell glob, anchored at both ends.
+ * '*' matches any characters including path separators.
+ */
+func GlobToRegex(glob string) string {
+	s := "^"
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			s += ".*"
+		case '?':
+			s += "."
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				s += `\[`
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			s += "[" + class + "]"
+			i += end + 1
+		default:
+			s += regexp.QuoteMeta(string(c))
+		}
+	}
+	return s + "$"
+}
+
+/*
+ * SplitFieldList splits value of @= and <<= checks on commas, trimming
+ * spaces
+ */
+func SplitFieldList(value string) []string {
+	retval := []string{}
+	for _, item := range strings.Split(value, ",") {
+		retval = append(retval, strings.TrimSpace(item))
+	}
+	return retval
+}
+
+/*
+ * IsFieldCriteria returns true if str has a field name and operator
+ */
+func IsFieldCriteria(str string) bool {
+	return gRxFieldCriteria.MatchString(strings.TrimLeft(str, " "))
+}
+
+/*
+ * CompileFieldCriteria compiles the regex of *= and %= checks, and
+ * validates values of numeric and CIDR checks.  Values containing
+ * criteria variables are only checked once substituted.
+ */
+func CompileFieldCriteria(fc *types.FieldCriteria) error {
+	fc.Regex = nil
+	if gRxCriteriaVar.MatchString(fc.Value) {
+		return nil
+	}
+	switch fc.Op {
+	case "*=", "%=":
+		s := fc.Value
+		if fc.Op == "%=" {
+			s = GlobToRegex(s)
+		}
+		if fc.IgnoreCase {
+			s = "(?i)" + s
+		}
+		rx, err := regexp.Compile(s)
+		if err != nil {
+			return fmt.Errorf("invalid pattern '%s' for %s: %w", fc.Value, fc.FieldName, err)
+		}
+		fc.Regex = rx
+	case "<", ">", "<=", ">=":
+		if _, err := strconv.ParseFloat(strings.TrimSpace(fc.Value), 64); err != nil {
+			return fmt.Errorf("invalid number '%s' for %s", fc.Value, fc.FieldName)
+		}
+	case "<<=":
+		for _, item := range SplitFieldList(fc.Value) {
+			if !strings.Contains(item, "/") {
+				if net.ParseIP(item) == nil {
+					return fmt.Errorf("invalid IP address '%s' for %s", item, fc.FieldName)
+				}
+			} else if _, _, err := net.ParseCIDR(item); err != nil {
+				return fmt.Errorf("invalid CIDR '%s' for %s", item, fc.FieldName)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * MatchFieldCriteria returns true if value satisfies field check.  Regex
+ * of *= and %= checks is compiled if criteria was not loaded from file.
+ */
+func MatchFieldCriteria(value string, fc *types.FieldCriteria) bool {
+	needle := fc.Value
+	if fc.IgnoreCase {
+		value = strings.ToLower(value)
+		needle = strings.ToLower(needle)
+	}
+	switch fc.Op {
+	case "=":
+		return value == needle
+	case "!=":
+		return value != needle
+	case "~=":
+		return strings.Contains(value, needle)
+	case "^=":
+		return strings.HasPrefix(value, needle)
+	case "$=":
+		return strings.HasSuffix(value, needle)
+	case "*=", "%=":
+		rx := fc.Regex
+		if rx == nil {
+			cp := *fc
+			if err := CompileFieldCriteria(&cp); err != nil || cp.Regex == nil {
+				fmt.Println("ERROR: invalid pattern", fc, err)
+				return false
+			}
+			rx = cp.Regex
+		}
+		return rx.MatchString(value)
+	case "@=":
+		return IsInFieldList(value, needle)
+	case "<<=":
+		return IsInNetworks(value, needle)
+	case "<", ">", "<=", ">=":
+		return CompareNumbers(value, fc.Op, needle)
+	default:
+		fmt.Println("ERROR: unsupported operator", fc.Op)
+	}
+	return false
+}
+
+/*
+ * IsInFieldList returns true if value is an item of comma separated list
+ */
+func IsInFieldList(value string, list string) bool {
+	for _, item := range SplitFieldList(list) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * IsInNetworks returns true if value is an IP address in a CIDR of
+ * comma separated list, or equal to an address, e.g. "10.0.0.0/8,::1"
+ */
+func IsInNetworks(value string, list string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	for _, item := range SplitFieldList(list) {
+		if !strings.Contains(item, "/") {
+			if other := net.ParseIP(item); other != nil && other.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, subnet, err := net.ParseCIDR(item); err == nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * CompareNumbers returns result of numeric comparison 'value op needle'.
+ * False if value is not a number.
+ */
+func CompareNumbers(value string, op string, needle string) bool {
+	a, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return false
+	}
+	b, err := strconv.ParseFloat(strings.TrimSpace(needle), 64)
+	if err != nil {
+		return false
+	}
+	switch op {
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "<=":
+		return a <= b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+/*
+ * CompileSubType compiles SubType of expected event with '*' wildcards
+ * to a case-insensitive regex, anchored at both ends.  Other characters
+ * are literal.  SubType without wildcards is compared with EqualFold.
+ * The legacy NETFLOW pattern, e.g. "TCP:*->victim-host:22", is always
+ * compiled, and is not anchored.
+ */
+func CompileSubType(exp *types.ExpectedEvent) error {
+	exp.SubTypeRegex = nil
+	isNetflow := strings.ToUpper(exp.EventType) == "NETFLOW"
+	if exp.SubType == "" || gRxCriteriaVar.MatchString(exp.SubType) {
+		return nil
+	}
+	if !isNetflow && (exp.SubType == "*" || !strings.Contains(exp.SubType, "*")) {
+		return nil
+	}
+	parts := strings.Split(exp.SubType, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	s := strings.Join(parts, ".*")
+	if !isNetflow {
+		s = "^" + s + "$"
+	}
+	rx, err := regexp.Compile("(?i)" + s)
+	if err != nil {
+		return fmt.Errorf("invalid sub type '%s': %w", exp.SubType, err)
+	}
+	exp.SubTypeRegex = rx
+	return nil
+}
+
+/*
+ * ParseFieldCriteria parses 'name[:i]<op>value'.  For FILE events,
+ * a value without an operator is a path.
+ */
+func ParseFieldCriteria(str string, eventType string) (*types.FieldCriteria, error) {
+	m := gRxFieldCriteria.FindStringSubmatch(strings.TrimLeft(str, " "))
+	if m == nil {
+		if eventType == "FILE" {
+			// assume it's a path
+			m = []string{str, "path", "", "=", str}
+		} else {
+			return nil, errors.New("no operator")
+		}
+	}
+	fc := &types.FieldCriteria{FieldName: m[1], IgnoreCase: len(m[2]) > 0, Op: m[3], Value: m[4]}
+	if err := CompileFieldCriteria(fc); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"testing"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchFieldCriteria(t *testing.T) {
+	tests := []struct {
+		value    string
+		criteria string
+		isMatch  bool
+	}{
+		{"whoami", "cmdline=whoami", true},
+		{"whoami /all", "cmdline=whoami", false},
+		{"x=y", "cmdline=x=y", true},
+		{"whoami", "cmdline!=id", true},
+		{"whoami", "cmdline!=whoami", false},
+		{"whoami /all", "cmdline~=/all", true},
+		{"whoami /all", "cmdline~=/ALL", false},
+		{"cat /etc/passwd", "cmdline*=^cat .*passwd$", true},
+		{"tac /etc/passwd", "cmdline*=^cat", false},
+		{"/usr/bin/whoami", "exe_path^=/usr/", true},
+		{"/bin/whoami", "exe_path^=/usr/", false},
+		{"/usr/bin/whoami", "exe_path$=/whoami", true},
+		{"/usr/bin/whoami.sh", "exe_path$=/whoami", false},
+		{"/tmp/dir/x.sh", "path%=/tmp/*.sh", true},
+		{"/tmp/x.sh.bak", "path%=/tmp/*.sh", false},
+		{"/tmp/a1", "path%=/tmp/[a-c]?", true},
+		{"/tmp/d1", "path%=/tmp/[!a-c]?", true},
+		{"/tmp/a1", "path%=/tmp/[!a-c]?", false},
+		{"22", "dst_port@=22, 2222", true},
+		{"2222", "dst_port@=22, 2222", true},
+		{"222", "dst_port@=22, 2222", false},
+		{"10.0.0.0/8", "dst_ip@=10.0.0.0/8", true},
+		{"10.1.2.3", "dst_ip@=10.0.0.0/8", false}, // @= does not match networks
+		{"10.1.2.3", "dst_ip<<=192.168.0.0/16, 10.0.0.0/8", true},
+		{"172.16.0.1", "dst_ip<<=10.0.0.0/8,::1", false},
+		{"::1", "dst_ip<<=10.0.0.0/8,::1", true},
+		{"0:0::1", "dst_ip<<=::1", true},
+		{"victim-host", "dst_ip<<=10.0.0.0/8", false},
+		{"22", "dst_port<1024", true},
+		{"8080", "dst_port<1024", false},
+		{"1024", "dst_port>1023", true},
+		{"1024", "dst_port>=1024", true},
+		{"-13", "exit_code<=0", true},
+		{"abc", "exit_code>0", false},
+
+		// :i case-insensitive
+		{"Whoami /all", "cmdline:i=whoami /ALL", true},
+		{"Whoami /all", "cmdline:i!=WHOAMI /ALL", false},
+		{"Whoami /all", "cmdline:i~=WHOAMI", true},
+		{"CAT /etc/passwd", "cmdline:i*=^cat", true},
+		{`C:\Windows\System32\CMD.EXE`, `exe_path:i^=c:\windows`, true},
+		{`C:\Windows\System32\CMD.EXE`, `exe_path:i$=\cmd.exe`, true},
+		{`C:\Windows\System32\CMD.EXE`, `exe_path$=\cmd.exe`, false},
+		{`C:\Windows\System32\CMD.EXE`, `exe_path:i%=c:\*\cmd.exe`, true},
+		{"SSHD", "name:i@=sshd,login", true},
+		{"SSHD", "name@=sshd,login", false},
+	}
+	for _, tt := range tests {
+		fc, err := ParseFieldCriteria(tt.criteria, "PROCESS")
+		assert.Nil(t, err, tt.criteria)
+		assert.Equal(t, tt.isMatch, MatchFieldCriteria(tt.value, fc), "%s %s", tt.value, tt.criteria)
+	}
+}
+
+func TestParseFieldCriteria(t *testing.T) {
+	tests := []struct {
+		criteria   string
+		eventType  string
+		fieldName  string
+		op         string
+		value      string
+		ignoreCase bool
+	}{
+		{"cmdline=whoami", "PROCESS", "cmdline", "=", "whoami", false},
+		{" cmdline:i~=WHOAMI", "PROCESS", "cmdline", "~=", "WHOAMI", true},
+		{"dst_ip<<=10.0.0.0/8", "NETFLOW", "dst_ip", "<<=", "10.0.0.0/8", false},
+		{"dst_port<=1024", "NETFLOW", "dst_port", "<=", "1024", false},
+		{"dst_port<#{port}", "NETFLOW", "dst_port", "<", "#{port}", false},
+		{"/etc/passwd", "FILE", "path", "=", "/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		fc, err := ParseFieldCriteria(tt.criteria, tt.eventType)
+		assert.Nil(t, err, tt.criteria)
+		assert.Equal(t, types.FieldCriteria{FieldName: tt.fieldName, Op: tt.op, Value: tt.value, IgnoreCase: tt.ignoreCase}, types.FieldCriteria{FieldName: fc.FieldName, Op: fc.Op, Value: fc.Value, IgnoreCase: fc.IgnoreCase}, tt.criteria)
+	}
+
+	// invalid patterns are load time errors
+	for _, criteria := range []string{"cmdline*=(", "dst_port<abc", "dst_ip<<=10.0.0.0/99", "dst_ip<<=victim-host", "no operator"} {
+		_, err := ParseFieldCriteria(criteria, "PROCESS")
+		assert.NotNil(t, err, criteria)
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob  string
+		regex string
+	}{
+		{"/tmp/*.sh", `^/tmp/.*\.sh$`},
+		{"/tmp/?", `^/tmp/.$`},
+		{"/tmp/[a-c]", `^/tmp/[a-c]$`},
+		{"/tmp/[!a-c]", `^/tmp/[^a-c]$`},
+		{"/tmp/[", `^/tmp/\[$`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.regex, GlobToRegex(tt.glob), tt.glob)
+	}
+}
+
+func TestCompileSubType(t *testing.T) {
+	tests := []struct {
+		eventType string
+		subType   string
+		isRegex   bool
+	}{
+		{"File", "WRITE", false},
+		{"File", "*", false},
+		{"File", "OPEN_*", true},
+		{"Netflow", "TCP:*->victim-host:22", true},
+		{"File", "#{subtype}*", false},
+	}
+	for _, tt := range tests {
+		exp := &types.ExpectedEvent{EventType: tt.eventType, SubType: tt.subType}
+		assert.Nil(t, CompileSubType(exp))
+		assert.Equal(t, tt.isRegex, exp.SubTypeRegex != nil, tt.subType)
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+/*
+ * MITRE ATT&CK Navigator layer json, to view coverage as a heatmap.
+ * See https://github.com/mitre-attack/attack-navigator/tree/master/layers
+ *
+ * Scores are 0-100.  Techniques without a score are listed with a
+ * comment only.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+type NavigatorVersions struct {
+	Attack    string `json:"attack"`
+	Navigator string `json:"navigator"`
+	Layer     string `json:"layer"`
+}
+
+type NavigatorFilters struct {
+	Platforms []string `json:"platforms"`
+}
+
+type NavigatorGradient struct {
+	Colors   []string `json:"colors"`
+	MinValue float64  `json:"minValue"`
+	MaxValue float64  `json:"maxValue"`
+}
+
+type NavigatorLegendItem struct {
+	Label string `json:"label"`
+	Color string `json:"color"`
+}
+
+type NavigatorMetadata struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NavigatorTechnique struct {
+	TechniqueID       string              `json:"techniqueID"`
+	Score             *float64            `json:"score,omitempty"`
+	Comment           string              `json:"comment,omitempty"`
+	Enabled           bool                `json:"enabled"`
+	Metadata          []NavigatorMetadata `json:"metadata,omitempty"`
+	ShowSubtechniques bool                `json:"showSubtechniques"`
+}
+
+type NavigatorLayer struct {
+	Name        string                `json:"name"`
+	Versions    NavigatorVersions     `json:"versions"`
+	Domain      string                `json:"domain"`
+	Description string                `json:"description"`
+	Filters     *NavigatorFilters     `json:"filters,omitempty"`
+	Gradient    NavigatorGradient     `json:"gradient"`
+	LegendItems []NavigatorLegendItem `json:"legendItems"`
+	Techniques  []*NavigatorTechnique `json:"techniques"`
+}
+
+var kNavigatorPlatforms = map[string]string{"linux": "Linux", "macos": "macOS", "windows": "Windows"}
+
+var (
+	kNavigatorRed    = "#ff6666"
+	kNavigatorYellow = "#ffe766"
+	kNavigatorGreen  = "#8ec843"
+)
+
+/*
+ * NewNavigatorLayer returns an empty layer scored from red (0) to
+ * green (100).  platform is linux, macos or windows, or empty for all.
+ */
+func NewNavigatorLayer(name string, description string, platform string) *NavigatorLayer {
+	layer := &NavigatorLayer{Name: name, Description: description, Domain: "enterprise-attack"}
+	layer.Versions = NavigatorVersions{Attack: "14", Navigator: "4.9.1", Layer: "4.5"}
+	layer.Gradient = NavigatorGradient{Colors: []string{kNavigatorRed, kNavigatorYellow, kNavigatorGreen}, MinValue: 0, MaxValue: 100}
+	layer.Techniques = []*NavigatorTechnique{}
+	if p, ok := kNavigatorPlatforms[strings.ToLower(platform)]; ok {
+		layer.Filters = &NavigatorFilters{Platforms: []string{p}}
+	}
+	return layer
+}
+
+/*
+ * Technique returns the entry of technique id, adding it if needed.
+ * Adding a sub-technique also adds its parent, with sub-techniques shown.
+ */
+func (layer *NavigatorLayer) Technique(tid string) *NavigatorTechnique {
+	for _, t := range layer.Techniques {
+		if t.TechniqueID == tid {
+			return t
+		}
+	}
+	t := &NavigatorTechnique{TechniqueID: tid, Enabled: true}
+	layer.Techniques = append(layer.Techniques, t)
+
+	if i := strings.Index(tid, "."); i > 0 {
+		layer.Technique(tid[:i]).ShowSubtechniques = true
+	}
+	return t
+}
+
+func (t *NavigatorTechnique) SetScore(score float64) {
+	t.Score = &score
+}
+
+func (t *NavigatorTechnique) AddComment(line string) {
+	if len(t.Comment) > 0 {
+		t.Comment += "\n"
+	}
+	t.Comment += line
+}
+
+func (t *NavigatorTechnique) AddMetadata(name string, value string) {
+	t.Metadata = append(t.Metadata, NavigatorMetadata{Name: name, Value: value})
+}
+
+/*
+ * NavigatorLayerFromResults scores each technique by the validation
+ * status of its tests: Validated is 100, Partial 50, NoTelemetry and
+ * Unexpected 0, averaged over those tests.  The comment lists each
+ * test with its status and match string.  Techniques with tests of
+ * other statuses only, and techniquesMissingTests, have no score.
+ */
+func NavigatorLayerFromResults(name string, platform string, progress []types.TestProgress, techniqueNames map[string]string, techniquesMissingTests []string) *NavigatorLayer {
+	layer := NewNavigatorLayer(name, "atomic-harness validation results", platform)
+	layer.LegendItems = []NavigatorLegendItem{
+		{Label: "Validated", Color: kNavigatorGreen},
+		{Label: "Partial", Color: kNavigatorYellow},
+		{Label: "NoTelemetry / Unexpected", Color: kNavigatorRed},
+	}
+
+	type counts struct{ numValidated, numPartial, numFailed int }
+	byTechnique := map[string]*counts{}
+
+	for _, p := range progress {
+		t := layer.Technique(p.Technique)
+		c, ok := byTechnique[p.Technique]
+		if !ok {
+			c = &counts{}
+			byTechnique[p.Technique] = c
+		}
+		switch p.Status {
+		case types.StatusValidateSuccess:
+			c.numValidated += 1
+		case types.StatusValidatePartial:
+			c.numPartial += 1
+		case types.StatusValidateFail, types.StatusValidateUnexpected:
+			c.numFailed += 1
+		}
+		line := fmt.Sprintf("#%s %s %s", p.TestIndex, p.Status, p.TestName)
+		if len(p.MatchString) > 0 {
+			line = fmt.Sprintf("#%s %s %s %s", p.TestIndex, p.Status, p.MatchString, p.TestName)
+		}
+		t.AddComment(line)
+	}
+
+	for tid, c := range byTechnique {
+		t := layer.Technique(tid)
+		if name, ok := techniqueNames[tid]; ok {
+			t.AddMetadata("name", name)
+		}
+		t.AddMetadata("validated", fmt.Sprintf("%d", c.numValidated))
+		t.AddMetadata("partial", fmt.Sprintf("%d", c.numPartial))
+		t.AddMetadata("failed", fmt.Sprintf("%d", c.numFailed))
+
+		numScored := c.numValidated + c.numPartial + c.numFailed
+		if numScored > 0 {
+			t.SetScore(math.Round((float64(c.numValidated) + 0.5*float64(c.numPartial)) * 100.0 / float64(numScored)))
+		}
+	}
+	for _, tid := range techniquesMissingTests {
+		layer.Technique(tid).AddComment("no atomic tests")
+	}
+	return layer
+}
+
+/*
+ * WriteNavigatorLayer saves layer to path, with techniques sorted by id
+ */
+func WriteNavigatorLayer(layer *NavigatorLayer, path string) error {
+	sort.SliceStable(layer.Techniques, func(i, j int) bool { return layer.Techniques[i].TechniqueID < layer.Techniques[j].TechniqueID })
+
+	jb, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.FromSlash(path), jb, 0644)
+}
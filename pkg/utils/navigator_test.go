@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNavigatorLayerFromResults(t *testing.T) {
+	progress := []types.TestProgress{
+		{Technique: "T1000", TestIndex: "1", TestName: "validated test", Status: types.StatusValidateSuccess, MatchString: "PF"},
+		{Technique: "T1000", TestIndex: "2", TestName: "partial test", Status: types.StatusValidatePartial, MatchString: "P<F>"},
+		{Technique: "T1001.001", TestIndex: "1", TestName: "failed test", Status: types.StatusValidateFail},
+		{Technique: "T1002", TestIndex: "1", TestName: "run failed", Status: types.StatusTestFail},
+	}
+	names := map[string]string{"T1000": "Some Technique"}
+	layer := NavigatorLayerFromResults("results", "linux", progress, names, []string{"T1003"})
+
+	assert.Equal(t, []string{"Linux"}, layer.Filters.Platforms)
+	byId := map[string]*NavigatorTechnique{}
+	for _, tech := range layer.Techniques {
+		byId[tech.TechniqueID] = tech
+	}
+	assert.Equal(t, 5, len(byId))
+
+	tech := byId["T1000"]
+	assert.Equal(t, 75.0, *tech.Score)
+	assert.Equal(t, "#1 Validated PF validated test\n#2 Partial P<F> partial test", tech.Comment)
+	assert.Equal(t, []NavigatorMetadata{{"name", "Some Technique"}, {"validated", "1"}, {"partial", "1"}, {"failed", "0"}}, tech.Metadata)
+
+	assert.Equal(t, 0.0, *byId["T1001.001"].Score)
+	assert.Nil(t, byId["T1001"].Score)
+	assert.True(t, byId["T1001"].ShowSubtechniques)
+
+	// not validated, or no tests, are not scored
+	assert.Nil(t, byId["T1002"].Score)
+	assert.Equal(t, "#1 TestFail run failed", byId["T1002"].Comment)
+	assert.Nil(t, byId["T1003"].Score)
+	assert.Equal(t, "no atomic tests", byId["T1003"].Comment)
+
+	assert.Nil(t, NavigatorLayerFromResults("results", "", progress, names, nil).Filters)
+}
+
+func TestWriteNavigatorLayer(t *testing.T) {
+	layer := NewNavigatorLayer("results", "", "macos")
+	layer.Technique("T1002").SetScore(100)
+	layer.Technique("T1001.001").SetScore(50)
+
+	path := filepath.Join(t.TempDir(), "attack_layer.json")
+	assert.Nil(t, WriteNavigatorLayer(layer, path))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	loaded := NavigatorLayer{}
+	assert.Nil(t, json.Unmarshal(data, &loaded))
+	assert.Equal(t, []string{"macOS"}, loaded.Filters.Platforms)
+	ids := []string{}
+	for _, tech := range loaded.Techniques {
+		ids = append(ids, tech.TechniqueID)
+	}
+	assert.Equal(t, []string{"T1001", "T1001.001", "T1002"}, ids)
+	assert.Equal(t, 50.0, *loaded.Techniques[1].Score)
+	assert.Nil(t, loaded.Techniques[0].Score)
+}
@@ -0,0 +1,48 @@
+package utils
+
+/*
+ * Helpers for files written to the results dir of a test
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	types "github.com/secureworks/atomic-harness/pkg/types"
+)
+
+// LoadRunSummary loads run_summary.json written by goartrun in resultsDir
+func LoadRunSummary(resultsDir string, dest *types.ScriptResults) error {
+	return loadJsonFile(filepath.FromSlash(resultsDir+"/run_summary.json"), dest)
+}
+
+// LoadRunSpec loads runspec.json written by harness in resultsDir
+func LoadRunSpec(resultsDir string, dest *types.RunSpec) error {
+	return loadJsonFile(filepath.FromSlash(resultsDir+"/runspec.json"), dest)
+}
+
+// LoadTestProgress loads status.json written by harness in results dir of run
+func LoadTestProgress(resultsDir string, dest *[]types.TestProgress) error {
+	return loadJsonFile(filepath.FromSlash(resultsDir+"/status.json"), dest)
+}
+
+// LoadValidateSummary loads validate_summary{suffix}.json written by harness in resultsDir
+func LoadValidateSummary(resultsDir string, suffix string, dest interface{}) error {
+	return loadJsonFile(filepath.FromSlash(resultsDir+"/validate_summary"+suffix+".json"), dest)
+}
+
+func loadJsonFile(path string, dest interface{}) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	if err = json.Unmarshal(body, dest); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package utils
+
+/*
+ * Helpers for the arguments and output files of telemetry tools,
+ * see "Telemetry and Matching" in README.md
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * ParseTimeRange parses the --ts argument of a telemetry tool in unix
+ * seconds, and returns the range in nanoseconds.  The end is inclusive
+ * of the whole second.  An empty arg is the whole time range.
+ */
+func ParseTimeRange(arg string) (int64, int64, error) {
+	if len(arg) == 0 {
+		return 0, int64(1 << 62), nil
+	}
+	a := strings.Split(arg, ",")
+	if len(a) != 2 {
+		return 0, 0, fmt.Errorf("expected start,end: %s", arg)
+	}
+	start, err := strconv.ParseInt(a[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.ParseInt(a[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start * int64(time.Second), (end+1)*int64(time.Second) - 1, nil
+}
+
+/*
+ * TelemToolSuffix returns the --suffix argument of a telemetry tool.
+ * Some versions of harness pass "''" for an empty suffix.
+ */
+func TelemToolSuffix(arg string) string {
+	if arg == "''" {
+		return ""
+	}
+	return arg
+}
+
+/*
+ * WriteTelemetryFiles writes simple_telemetry{suffix}.json and
+ * telemetry{suffix}.json in dir, one event per line.  Line i of
+ * rawLines is the native event of line i of simpleLines.
+ */
+func WriteTelemetryFiles(dir string, suffix string, simpleLines [][]byte, rawLines [][]byte) error {
+	if len(simpleLines) != len(rawLines) {
+		return fmt.Errorf("%d simple events but %d native events", len(simpleLines), len(rawLines))
+	}
+	simpleFile, err := os.Create(filepath.Join(dir, "simple_telemetry"+suffix+".json"))
+	if err != nil {
+		return err
+	}
+	defer simpleFile.Close()
+	rawFile, err := os.Create(filepath.Join(dir, "telemetry"+suffix+".json"))
+	if err != nil {
+		return err
+	}
+	defer rawFile.Close()
+
+	simpleWriter := bufio.NewWriter(simpleFile)
+	rawWriter := bufio.NewWriter(rawFile)
+	for i := range simpleLines {
+		simpleWriter.Write(simpleLines[i])
+		simpleWriter.WriteString("\n")
+		rawWriter.Write(rawLines[i])
+		rawWriter.WriteString("\n")
+	}
+	if err = simpleWriter.Flush(); err != nil {
+		return err
+	}
+	return rawWriter.Flush()
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	start, end, err := ParseTimeRange("100,200")
+	assert.Nil(t, err)
+	assert.Equal(t, 100*int64(time.Second), start)
+	assert.Equal(t, 201*int64(time.Second)-1, end)
+
+	start, end, err = ParseTimeRange("")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), start)
+	assert.Equal(t, int64(1<<62), end)
+
+	_, _, err = ParseTimeRange("100")
+	assert.NotNil(t, err)
+	_, _, err = ParseTimeRange("100,abc")
+	assert.NotNil(t, err)
+}
+
+func TestTelemToolSuffix(t *testing.T) {
+	assert.Equal(t, "", TelemToolSuffix("''"))
+	assert.Equal(t, "", TelemToolSuffix(""))
+	assert.Equal(t, "_a", TelemToolSuffix("_a"))
+}
+
+func TestWriteTelemetryFiles(t *testing.T) {
+	dir := t.TempDir()
+	err := WriteTelemetryFiles(dir, "_a", [][]byte{[]byte(`{"a":1}`), []byte(`{"a":2}`)}, [][]byte{[]byte(`{"r":1}`), []byte(`{"r":2}`)})
+	assert.Nil(t, err)
+
+	simple, err := os.ReadFile(filepath.Join(dir, "simple_telemetry_a.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"a\":1}\n{\"a\":2}\n", string(simple))
+	raw, err := os.ReadFile(filepath.Join(dir, "telemetry_a.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"r\":1}\n{\"r\":2}\n", string(raw))
+
+	err = WriteTelemetryFiles(dir, "", [][]byte{[]byte(`{}`)}, nil)
+	assert.NotNil(t, err)
+}